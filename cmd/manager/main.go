@@ -13,6 +13,9 @@ import (
 
 	"xzbc-redis-cluster/pkg/apis"
 	"xzbc-redis-cluster/pkg/controller"
+	redisclustercontroller "xzbc-redis-cluster/pkg/controller/rediscluster"
+	"xzbc-redis-cluster/pkg/webhook"
+	redisclusterwebhook "xzbc-redis-cluster/pkg/webhook/rediscluster"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
@@ -36,6 +39,14 @@ var (
 	metricsPort         int32 = 8383
 	operatorMetricsPort int32 = 8686
 )
+
+// Admission webhooks are disabled by default because they need a serving certificate,
+// see deploy/webhook.yaml.
+var (
+	enableWebhooks bool
+	webhookPort    int
+	webhookCertDir string
+)
 var log = logf.Log.WithName("cmd")
 
 func printVersion() {
@@ -49,10 +60,22 @@ func main() {
 	// be added before calling pflag.Parse().
 	pflag.CommandLine.AddFlagSet(zap.FlagSet())
 
+	// Add the flags that configure the RedisCluster defaults applied by the
+	// mutating webhook.
+	pflag.CommandLine.AddFlagSet(redisclusterwebhook.FlagSet())
+
+	// Add the flags that configure the RedisCluster controller.
+	pflag.CommandLine.AddFlagSet(redisclustercontroller.FlagSet())
+
 	// Add flags registered by imported packages (e.g. glog and
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	pflag.BoolVar(&enableWebhooks, "enable-webhooks", false, "Serve the RedisCluster admission webhooks")
+	pflag.IntVar(&webhookPort, "webhook-port", 9443, "Port the admission webhook server listens on")
+	pflag.StringVar(&webhookCertDir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs",
+		"Directory containing tls.crt and tls.key for the admission webhook server")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
@@ -93,6 +116,7 @@ func main() {
 		Namespace:          namespace,
 		MapperProvider:     restmapper.NewDynamicRESTMapper,
 		MetricsBindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		Port:               webhookPort,
 	})
 	if err != nil {
 		log.Error(err, "")
@@ -113,6 +137,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup all Webhooks
+	if enableWebhooks {
+		if err := redisclusterwebhook.ValidateDefaults(); err != nil {
+			log.Error(err, "Invalid RedisCluster defaults")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().CertDir = webhookCertDir
+		if err := webhook.AddToManager(mgr); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
 	if err = serveCRMetrics(cfg); err != nil {
 		log.Info("Could not generate and serve custom resource metrics", "error", err.Error())
 	}
@@ -1,9 +1,9 @@
 module xzbc-redis-cluster
 
 require (
-	github.com/ericchiang/k8s v1.2.0
 	github.com/go-openapi/spec v0.17.2
 	github.com/operator-framework/operator-sdk v0.0.0-20191210214720-cea92d5be4cf
+	github.com/prometheus/client_golang v1.0.0
 	github.com/spf13/pflag v1.0.3
 	k8s.io/api v0.0.0-20190918155943-95b840bb6a1f
 	k8s.io/apimachinery v0.0.0-20190913080033-27d36303b655
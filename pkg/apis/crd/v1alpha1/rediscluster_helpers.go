@@ -0,0 +1,218 @@
+package v1alpha1
+
+//没有设置replicasPerShard时，每个master带一个slave
+const DefaultReplicasPerShard int32 = 1
+
+const (
+	//redis监听的端口，开启TLS之后只监听TLS端口
+	RedisPort    int32 = 6379
+	RedisTLSPort int32 = 6380
+	//集群总线的端口是服务端口加10000
+	ClusterBusPortOffset int32 = 10000
+)
+
+//tls里的key没有设置时，使用kubernetes.io/tls类型Secret的key
+const (
+	DefaultTLSCertKey = "tls.crt"
+	DefaultTLSKeyKey  = "tls.key"
+	DefaultTLSCAKey   = "ca.crt"
+)
+
+//clustermode为false时默认的sentinel个数
+const DefaultSentinelReplicas int32 = 3
+
+//sentinel监听的端口
+const SentinelPort int32 = 26379
+
+const (
+	//clustermode为false时，operator把当前的角色写在redis pod的这个label上，master service通过它选择pod
+	RoleLabel  = "crd.xzbc.com.cn/role"
+	RoleMaster = "master"
+	RoleSlave  = "slave"
+	//sentinel pod的label，值是RedisCluster的名字
+	SentinelLabel = "crd.xzbc.com.cn/sentinel"
+)
+
+//老版本的operator把上一次处理过的spec记录在这个annotation上
+const LegacySpecAnnotation = "crd.xzbc.com.cn/spec"
+
+//auth.key没有设置时，从Secret的这个key读取密码
+const DefaultAuthSecretKey = "password"
+
+// PausedAnnotation set to "true" pauses the reconciliation like spec.paused
+const PausedAnnotation = "crd.xzbc.com.cn/paused"
+
+// RequireApprovalAnnotation set to "true" works like spec.updateStrategy.requireApproval
+const RequireApprovalAnnotation = "crd.xzbc.com.cn/require-approval"
+
+// ApprovedPlanAnnotation holds the name of the approved status.plan
+const ApprovedPlanAnnotation = "crd.xzbc.com.cn/approved-plan"
+
+// GetReplicasPerShard returns the number of slaves each master should have.
+// The deprecated replicasPerMaster field is honoured when replicasPerShard is unset.
+func (in *RedisClusterSpec) GetReplicasPerShard() int32 {
+	if in.ReplicasPerShard != nil {
+		return *in.ReplicasPerShard
+	}
+	if in.ReplicasPerMaster != nil {
+		return *in.ReplicasPerMaster
+	}
+	return DefaultReplicasPerShard
+}
+
+// GetShards returns the number of masters. For legacy specs that only set
+// replicas, it is derived from the pod count. Without cluster mode there is a
+// single master.
+func (in *RedisClusterSpec) GetShards() int32 {
+	if !in.IsClusterMode() {
+		return 1
+	}
+	if in.Shards != nil {
+		return *in.Shards
+	}
+	if in.Replicas != nil {
+		return *in.Replicas / (in.GetReplicasPerShard() + 1)
+	}
+	return 0
+}
+
+// GetClusterSize returns the number of redis pods, shards x (replicasPerShard + 1)
+func (in *RedisClusterSpec) GetClusterSize() int32 {
+	return in.GetShards() * (in.GetReplicasPerShard() + 1)
+}
+
+// IsLegacySizing reports whether the spec still uses the flat replicas count
+func (in *RedisClusterSpec) IsLegacySizing() bool {
+	return in.IsClusterMode() && in.Shards == nil && in.Replicas != nil
+}
+
+// IsClusterMode reports whether the pods form a Redis Cluster. An unset
+// clustermode means cluster mode, which is what every CR got before the
+// master/replica topology existed.
+func (in *RedisClusterSpec) IsClusterMode() bool {
+	return in.ClusterMode == nil || *in.ClusterMode
+}
+
+// GetSentinelReplicas returns the number of sentinel pods
+func (in *RedisClusterSpec) GetSentinelReplicas() int32 {
+	if in.Sentinel == nil || in.Sentinel.Replicas == nil {
+		return DefaultSentinelReplicas
+	}
+	return *in.Sentinel.Replicas
+}
+
+// GetSentinelQuorum returns the number of sentinels that must agree the master is down
+func (in *RedisClusterSpec) GetSentinelQuorum() int32 {
+	if in.Sentinel == nil || in.Sentinel.Quorum == nil {
+		return in.GetSentinelReplicas()/2 + 1
+	}
+	return *in.Sentinel.Quorum
+}
+
+// GetSentinelImage returns the image of the sentinel pods
+func (in *RedisClusterSpec) GetSentinelImage() string {
+	if in.Sentinel == nil || len(in.Sentinel.Image) == 0 {
+		return in.Image
+	}
+	return in.Sentinel.Image
+}
+
+// AuthEnabled reports whether the cluster requires a password
+func (in *RedisClusterSpec) AuthEnabled() bool {
+	return in.Auth != nil && len(in.Auth.SecretRef.Name) > 0
+}
+
+// GetAuthSecretKey returns the key of the password in the auth Secret
+func (in *RedisClusterSpec) GetAuthSecretKey() string {
+	if in.Auth == nil || len(in.Auth.Key) == 0 {
+		return DefaultAuthSecretKey
+	}
+	return in.Auth.Key
+}
+
+// TLSEnabled reports whether client, bus and replication traffic use TLS
+func (in *RedisClusterSpec) TLSEnabled() bool {
+	return in.TLS != nil && len(in.TLS.SecretRef.Name) > 0
+}
+
+// GetRedisPort returns the port clients connect to
+func (in *RedisClusterSpec) GetRedisPort() int32 {
+	if in.TLSEnabled() {
+		return RedisTLSPort
+	}
+	return RedisPort
+}
+
+// GetClusterBusPort returns the port of the cluster bus
+func (in *RedisClusterSpec) GetClusterBusPort() int32 {
+	return in.GetRedisPort() + ClusterBusPortOffset
+}
+
+// GetTLSCertKey returns the key of the certificate in the TLS Secret
+func (in *RedisClusterSpec) GetTLSCertKey() string {
+	if in.TLS == nil || len(in.TLS.CertKey) == 0 {
+		return DefaultTLSCertKey
+	}
+	return in.TLS.CertKey
+}
+
+// GetTLSKeyKey returns the key of the private key in the TLS Secret
+func (in *RedisClusterSpec) GetTLSKeyKey() string {
+	if in.TLS == nil || len(in.TLS.KeyKey) == 0 {
+		return DefaultTLSKeyKey
+	}
+	return in.TLS.KeyKey
+}
+
+// GetTLSCAKey returns the key of the CA certificate in the TLS Secret
+func (in *RedisClusterSpec) GetTLSCAKey() string {
+	if in.TLS == nil || len(in.TLS.CAKey) == 0 {
+		return DefaultTLSCAKey
+	}
+	return in.TLS.CAKey
+}
+
+// HasStaleClusterMode reports whether clustermode: false was persisted by an
+// operator version that ignored the field. Those clusters were always created
+// as a Redis Cluster and recorded their spec in the legacy annotation.
+func (in *RedisCluster) HasStaleClusterMode() bool {
+	_, ok := in.Annotations[LegacySpecAnnotation]
+	return ok && in.Spec.ClusterMode != nil && !*in.Spec.ClusterMode
+}
+
+// GetDeletionPolicy returns the deletion policy, Retain when unset
+func (in *RedisClusterSpec) GetDeletionPolicy() DeletionPolicy {
+	if len(in.DeletionPolicy) == 0 {
+		return DeletionPolicyRetain
+	}
+	return in.DeletionPolicy
+}
+
+// GetExecutor returns who runs the create and scale operations, Job when unset
+func (in *RedisClusterSpec) GetExecutor() ExecutorType {
+	if in.UpdateStrategy == nil || len(in.UpdateStrategy.Executor) == 0 {
+		return ExecutorJob
+	}
+	return in.UpdateStrategy.Executor
+}
+
+// IsRunning reports whether the operation has neither finished nor failed
+func (in *RedisClusterOperation) IsRunning() bool {
+	return in != nil && in.Phase != OperationDone && in.Phase != OperationFailed
+}
+
+// IsFailedAt reports whether the operation failed for the given metadata.generation
+func (in *RedisClusterOperation) IsFailedAt(generation int64) bool {
+	return in != nil && in.Phase == OperationFailed && in.Generation == generation
+}
+
+// IsPaused reports whether spec.paused or the paused annotation is set
+func (in *RedisCluster) IsPaused() bool {
+	return in.Spec.Paused || in.Annotations[PausedAnnotation] == "true"
+}
+
+// RequiresApproval reports whether scaling waits for status.plan to be approved
+func (in *RedisCluster) RequiresApproval() bool {
+	return (in.Spec.UpdateStrategy != nil && in.Spec.UpdateStrategy.RequireApproval) ||
+		in.Annotations[RequireApprovalAnnotation] == "true"
+}
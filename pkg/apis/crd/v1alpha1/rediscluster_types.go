@@ -3,7 +3,6 @@ package v1alpha1
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1 "k8s.io/api/core/v1"
-	appsv1 "k8s.io/api/apps/v1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -15,23 +14,355 @@ type RedisClusterSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
-	Replicas *int32 `json:"replicas"`
+	// 集群的分片个数，也就是master的个数
+	// +optional
+	Shards *int32 `json:"shards,omitempty"`
+	// 每个分片里slave的个数，不设置的时候默认为1
+	// 创建集群和扩缩容时按这个值分组，每组的第一个节点是master，其余的是它的slave
+	// +optional
+	ReplicasPerShard *int32 `json:"replicasPerShard,omitempty"`
+	// Deprecated: StatefulSet的pod总数，使用shards和replicasPerShard代替
+	// 只设置了replicas的老的CR，controller会把它换算成shards和replicasPerShard
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
 	Image string `json:"image"`
-	ClusterMode bool `json:"clustermode"`
+	// 是否部署成redis cluster，不设置的时候默认为true
+	// 设置为false时部署一个master和replicasPerShard个slave，由sentinel负责故障转移
+	// +optional
+	ClusterMode *bool `json:"clustermode,omitempty"`
 	Storage string `json:"storage"`
 	StorageClassName string `json:"storageClassName"`
 	Resources corev1.ResourceRequirements `json:"resources"`
 	RedisTribImage string `json:"redistribimage"`
+	// 额外的redis.conf配置，会覆盖operator内置的同名配置
+	// cluster-enabled、dir、cluster-config-file等operator依赖的配置不能修改
+	// 可以出现多次的配置（例如save）用换行分隔多个值
+	// +optional
+	Config map[string]string `json:"config,omitempty"`
+	// 开启密码认证，密码保存在Secret里，会设置到每个节点的requirepass和masterauth
+	// +optional
+	Auth *RedisClusterAuth `json:"auth,omitempty"`
+	// 开启TLS，客户端连接、集群总线和主从复制都使用TLS
+	// 开启之后redis只监听TLS端口6380，集群总线端口是16380
+	// +optional
+	TLS *RedisClusterTLS `json:"tls,omitempty"`
+	// 合并到redis pod模板上的调度和安全配置，sentinel pod也会使用
+	// +optional
+	PodTemplate *RedisPodTemplate `json:"podTemplate,omitempty"`
+	// clustermode为false时sentinel的配置
+	// +optional
+	Sentinel *RedisSentinelSpec `json:"sentinel,omitempty"`
+	// 删除RedisCluster时如何处理数据卷，默认是Retain，保留PVC
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// deletionPolicy为Snapshot时创建VolumeSnapshot使用的VolumeSnapshotClass，不设置时使用集群默认的
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+	// 暂停operator对这个集群的所有修改操作，只更新status，也可以用crd.xzbc.com.cn/paused: "true"这个annotation
+	// 恢复之后先完成暂停前正在进行的操作，再处理暂停期间spec的变化
+	// 删除RedisCluster时的清理不受暂停的影响
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+	// 创建集群和扩缩容的执行方式
+	// +optional
+	UpdateStrategy *RedisClusterUpdateStrategy `json:"updateStrategy,omitempty"`
+	// Deprecated: 使用replicasPerShard代替
+	// +optional
+	ReplicasPerMaster *int32 `json:"replicasPerMaster,omitempty"`
+}
+
+// DeletionPolicy decides what happens to the data volumes when a RedisCluster is deleted
+type DeletionPolicy string
+
+const (
+	// 保留PVC，重新创建同名的RedisCluster时会继续使用原来的数据
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// 删除PVC
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// 给每个PVC创建VolumeSnapshot，全部可用之后再删除PVC
+	DeletionPolicySnapshot DeletionPolicy = "Snapshot"
+)
+
+// ExecutorType decides who runs the create and scale operations
+type ExecutorType string
+
+const (
+	// 创建job，由redistribimage里的程序连接redis节点执行，operator等待job结束
+	ExecutorJob ExecutorType = "Job"
+	// operator直接连接redis pod，每次Reconcile执行一步，不需要redistribimage
+	// operator必须能访问pod的ip
+	ExecutorOperator ExecutorType = "Operator"
+)
+
+// RedisClusterUpdateStrategy controls how topology changes are carried out
+type RedisClusterUpdateStrategy struct {
+	// 默认是Job，正在进行的操作不受修改的影响
+	// +optional
+	Executor ExecutorType `json:"executor,omitempty"`
+	// 扩缩容之前先把计划发布到status.plan里，计划被批准之后才开始执行
+	// 也可以用crd.xzbc.com.cn/require-approval: "true"这个annotation
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// RedisClusterAuth references the Secret holding the redis password
+type RedisClusterAuth struct {
+	// 保存密码的Secret，必须和RedisCluster在同一个namespace
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+	// Secret里保存密码的key，默认是password
+	// +optional
+	Key string `json:"key,omitempty"`
+}
 
+// RedisClusterTLS references the Secret holding the certificate, key and CA
+type RedisClusterTLS struct {
+	// 保存证书的Secret，必须和RedisCluster在同一个namespace
+	// 节点之间、operator和job连接节点时都使用这个证书
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+	// Secret里保存证书的key，默认是tls.crt
+	// +optional
+	CertKey string `json:"certKey,omitempty"`
+	// Secret里保存私钥的key，默认是tls.key
+	// +optional
+	KeyKey string `json:"keyKey,omitempty"`
+	// Secret里保存CA证书的key，默认是ca.crt
+	// +optional
+	CAKey string `json:"caKey,omitempty"`
+}
+
+// RedisPodTemplate holds the pod fields users may set on the generated StatefulSets.
+// Containers, volumes and selectors stay owned by the operator.
+type RedisPodTemplate struct {
+	// 额外的pod label，不能覆盖operator使用的label
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// 额外的pod annotation，operator设置的annotation优先
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// +optional
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+	// 私有镜像仓库的secret，创建集群和扩缩容的job也会使用
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// RedisSentinelSpec defines the sentinels watching the master when clustermode is false
+type RedisSentinelSpec struct {
+	// sentinel的个数，默认为3
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// 判断master下线需要的sentinel个数，默认为replicas/2+1
+	// +optional
+	Quorum *int32 `json:"quorum,omitempty"`
+	// sentinel使用的镜像，默认和redis相同
+	// +optional
+	Image string `json:"image,omitempty"`
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// RedisClusterPhase is a simple, high-level summary of where the RedisCluster is in its lifecycle
+type RedisClusterPhase string
+
+const (
+	// StatefulSet及相关资源正在创建，redis节点还没有全部就绪
+	RedisClusterPhaseCreating RedisClusterPhase = "Creating"
+	// redis节点已经就绪，create job或者operator正在初始化集群
+	RedisClusterPhaseBootstrapping RedisClusterPhase = "Bootstrapping"
+	// 集群已经组建完成，cluster_state:ok，所有节点都在线
+	RedisClusterPhaseReady RedisClusterPhase = "Ready"
+	// 正在扩容或者缩容
+	RedisClusterPhaseScaling RedisClusterPhase = "Scaling"
+	// 集群已经组建完成，但是有节点不在线或者cluster_state不是ok
+	RedisClusterPhaseDegraded RedisClusterPhase = "Degraded"
+	// 初始化或者扩缩容失败
+	RedisClusterPhaseFailed RedisClusterPhase = "Failed"
+)
+
+// RedisClusterConditionType is the type of a RedisClusterCondition
+type RedisClusterConditionType string
+
+const (
+	// 集群可以正常提供服务
+	RedisClusterConditionReady RedisClusterConditionType = "Ready"
+	// 集群已经完成初始化，16384个slot都已经分配
+	RedisClusterConditionBootstrapped RedisClusterConditionType = "Bootstrapped"
+	// 正在执行扩缩容
+	RedisClusterConditionScaling RedisClusterConditionType = "Scaling"
+	// operator已经暂停对这个集群的修改
+	RedisClusterConditionPaused RedisClusterConditionType = "Paused"
+	// status.plan里的扩缩容计划正在等待批准
+	RedisClusterConditionPlanPending RedisClusterConditionType = "PlanPending"
+)
+
+// RedisClusterCondition describes one aspect of the observed state of a RedisCluster.
+// It mirrors the shape of the upstream metav1.Condition, which is not available in
+// the Kubernetes 1.14 libraries this operator is built against.
+type RedisClusterCondition struct {
+	Type               RedisClusterConditionType `json:"type"`
+	Status             corev1.ConditionStatus    `json:"status"`
+	ObservedGeneration int64                     `json:"observedGeneration,omitempty"`
+	LastTransitionTime metav1.Time               `json:"lastTransitionTime,omitempty"`
+	Reason             string                    `json:"reason,omitempty"`
+	Message            string                    `json:"message,omitempty"`
+}
+
+// RedisClusterNode is the view of a single redis node, built from CLUSTER NODES
+type RedisClusterNode struct {
+	PodName string `json:"podName"`
+	IP      string `json:"ip,omitempty"`
+	NodeID  string `json:"nodeID,omitempty"`
+	// master或者slave
+	Role     string `json:"role,omitempty"`
+	MasterID string `json:"masterID,omitempty"`
+	// slot的范围，格式和CLUSTER NODES一致，例如：0-5460
+	Slots []string `json:"slots,omitempty"`
+}
+
+// OperationType is the kind of operation that changes the cluster topology
+type OperationType string
+
+const (
+	// 创建集群，由create job或者operator分配slot
+	OperationCreate OperationType = "Create"
+	// 扩容，由scale job或者operator把slot迁移到新的节点上
+	OperationScaleUp OperationType = "ScaleUp"
+	// 缩容，由scale job或者operator把slot从要删除的节点上迁移走
+	OperationScaleDown OperationType = "ScaleDown"
+)
+
+// OperationPhase is a step of an operation
+type OperationPhase string
+
+const (
+	// 已经创建了job，等待job结束
+	OperationJobCreated OperationPhase = "JobCreated"
+	// operator执行时：等待操作涉及的pod全部就绪
+	OperationWaitingForNodes OperationPhase = "WaitingForNodes"
+	// operator执行时：创建集群，或者把新的节点加入集群并设置主从关系
+	OperationJoiningNodes OperationPhase = "JoiningNodes"
+	// operator执行时：按rebalance计划迁移slot，每次Reconcile迁移一批
+	OperationMigratingSlots OperationPhase = "MigratingSlots"
+	// operator执行时：缩容时把要删除的节点移出集群
+	OperationRemovingNodes OperationPhase = "RemovingNodes"
+	// 缩容时要删除的节点上的slot已经迁移完，并且已经从集群中移除
+	OperationSlotsDrained OperationPhase = "SlotsDrained"
+	// 缩容时已经减少了StatefulSet的副本数，等待多余的pod退出
+	OperationStatefulSetShrunk OperationPhase = "StatefulSetShrunk"
+	OperationDone              OperationPhase = "Done"
+	// job失败或者超时，需要人工处理，修改spec之后会重新开始
+	// operator执行时一个阶段超过超时时间没有进展也会进入Failed
+	OperationFailed OperationPhase = "Failed"
+)
+
+// RedisClusterOperation records the progress of the operation running on a cluster
+type RedisClusterOperation struct {
+	Type  OperationType  `json:"type"`
+	Phase OperationPhase `json:"phase"`
+	// 操作前后的pod个数，创建集群时FromSize为0
+	FromSize int32 `json:"fromSize"`
+	ToSize   int32 `json:"toSize"`
+	// 执行这次操作的方式，老版本operator记录的操作为空，都是Job
+	// +optional
+	Executor ExecutorType `json:"executor,omitempty"`
+	// 开启审批时，这次操作执行的计划的名字
+	// +optional
+	Plan string `json:"plan,omitempty"`
+	// 执行这次操作的job，Executor为Operator时为空
+	JobName string `json:"jobName,omitempty"`
+	// 扩缩容迁移的slot个数
+	// +optional
+	Slots int32 `json:"slots,omitempty"`
+	// 发起这次操作的metadata.generation
+	Generation         int64       `json:"generation,omitempty"`
+	StartTime          metav1.Time `json:"startTime,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// 进入Done或者Failed的时间
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// 失败的原因，operator执行时也记录当前的进度
+	Message string `json:"message,omitempty"`
+}
+
+// RedisClusterPlan is a topology change computed in plan mode, waiting for approval
+type RedisClusterPlan struct {
+	// 批准时把这个名字写到crd.xzbc.com.cn/approved-plan这个annotation上
+	// 集群的拓扑变化之后计划会重新计算，名字也会变化，需要重新批准
+	Name     string `json:"name"`
+	FromSize int32  `json:"fromSize"`
+	ToSize   int32  `json:"toSize"`
+	// 计算这个计划时的metadata.generation
+	Generation int64 `json:"generation,omitempty"`
+	// 要加入集群的pod
+	// +optional
+	AddedNodes []RedisClusterPlanNode `json:"addedNodes,omitempty"`
+	// 要移出集群的pod
+	// +optional
+	RemovedNodes []RedisClusterPlanNode `json:"removedNodes,omitempty"`
+	// 按执行顺序排列的slot迁移
+	// +optional
+	Moves []RedisClusterSlotMove `json:"moves,omitempty"`
+	// 迁移的slot总数
+	// +optional
+	Slots        int32       `json:"slots,omitempty"`
+	CreationTime metav1.Time `json:"creationTime,omitempty"`
+}
+
+// RedisClusterPlanNode is a pod added to or removed from the cluster by a plan
+type RedisClusterPlanNode struct {
+	PodName string `json:"podName"`
+	// master或者slave
+	Role string `json:"role,omitempty"`
+	// slave复制的master所在的pod
+	// +optional
+	MasterPodName string `json:"masterPodName,omitempty"`
+}
+
+// RedisClusterSlotMove is one step of a plan, moving slots between two masters
+type RedisClusterSlotMove struct {
+	// 迁出和迁入slot的master所在的pod
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// slot的范围，格式和CLUSTER NODES一致，例如：0-5460
+	Slots []string `json:"slots"`
+	Count int32    `json:"count"`
 }
 
 // RedisClusterStatus defines the observed state of RedisCluster
 // +k8s:openapi-gen=true
 type RedisClusterStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
-	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
-	appsv1.StatefulSetStatus `json:",inline"`
+	Phase RedisClusterPhase `json:"phase,omitempty"`
+	// lastAppliedSpec和spec一致时的metadata.generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// 最近一次已经生效到集群上的spec，和spec比较判断是否需要更新或者扩缩容
+	// +optional
+	LastAppliedSpec *RedisClusterSpec `json:"lastAppliedSpec,omitempty"`
+	// StatefulSet里的副本数和就绪副本数
+	Replicas      int32 `json:"replicas"`
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// CLUSTER INFO里的cluster_state
+	ClusterState string                  `json:"clusterState,omitempty"`
+	Conditions   []RedisClusterCondition `json:"conditions,omitempty"`
+	Nodes        []RedisClusterNode      `json:"nodes,omitempty"`
+	// 最近一次创建或扩缩容的进度，operator重启之后从这里继续
+	// 同一个集群同时只会有一个操作在进行
+	// +optional
+	Operation *RedisClusterOperation `json:"operation,omitempty"`
+	// 最近的若干次操作，按开始时间排序，最新的在最后
+	// 正在进行的操作和status.operation一起更新
+	// +optional
+	History []RedisClusterOperation `json:"history,omitempty"`
+	// spec.updateStrategy.requireApproval开启时，等待批准的扩缩容计划
+	// +optional
+	Plan *RedisClusterPlan `json:"plan,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -40,6 +371,11 @@ type RedisClusterStatus struct {
 // +k8s:openapi-gen=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:path=redisclusters,scope=Namespaced
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".status.replicas"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyReplicas"
+// +kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.clusterState"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type RedisCluster struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -5,6 +5,7 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -36,6 +37,40 @@ func (in *RedisCluster) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterAuth) DeepCopyInto(out *RedisClusterAuth) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterAuth.
+func (in *RedisClusterAuth) DeepCopy() *RedisClusterAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterCondition) DeepCopyInto(out *RedisClusterCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterCondition.
+func (in *RedisClusterCondition) DeepCopy() *RedisClusterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RedisClusterList) DeepCopyInto(out *RedisClusterList) {
 	*out = *in
@@ -69,15 +104,181 @@ func (in *RedisClusterList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterNode) DeepCopyInto(out *RedisClusterNode) {
+	*out = *in
+	if in.Slots != nil {
+		in, out := &in.Slots, &out.Slots
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterNode.
+func (in *RedisClusterNode) DeepCopy() *RedisClusterNode {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterOperation) DeepCopyInto(out *RedisClusterOperation) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterOperation.
+func (in *RedisClusterOperation) DeepCopy() *RedisClusterOperation {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterOperation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterPlan) DeepCopyInto(out *RedisClusterPlan) {
+	*out = *in
+	if in.AddedNodes != nil {
+		in, out := &in.AddedNodes, &out.AddedNodes
+		*out = make([]RedisClusterPlanNode, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemovedNodes != nil {
+		in, out := &in.RemovedNodes, &out.RemovedNodes
+		*out = make([]RedisClusterPlanNode, len(*in))
+		copy(*out, *in)
+	}
+	if in.Moves != nil {
+		in, out := &in.Moves, &out.Moves
+		*out = make([]RedisClusterSlotMove, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.CreationTime.DeepCopyInto(&out.CreationTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterPlan.
+func (in *RedisClusterPlan) DeepCopy() *RedisClusterPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterPlanNode) DeepCopyInto(out *RedisClusterPlanNode) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterPlanNode.
+func (in *RedisClusterPlanNode) DeepCopy() *RedisClusterPlanNode {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterPlanNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterSlotMove) DeepCopyInto(out *RedisClusterSlotMove) {
+	*out = *in
+	if in.Slots != nil {
+		in, out := &in.Slots, &out.Slots
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterSlotMove.
+func (in *RedisClusterSlotMove) DeepCopy() *RedisClusterSlotMove {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterSlotMove)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RedisClusterSpec) DeepCopyInto(out *RedisClusterSpec) {
 	*out = *in
+	if in.Shards != nil {
+		in, out := &in.Shards, &out.Shards
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ReplicasPerShard != nil {
+		in, out := &in.ReplicasPerShard, &out.ReplicasPerShard
+		*out = new(int32)
+		**out = **in
+	}
 	if in.Replicas != nil {
 		in, out := &in.Replicas, &out.Replicas
 		*out = new(int32)
 		**out = **in
 	}
+	if in.ClusterMode != nil {
+		in, out := &in.ClusterMode, &out.ClusterMode
+		*out = new(bool)
+		**out = **in
+	}
 	in.Resources.DeepCopyInto(&out.Resources)
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(RedisClusterAuth)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(RedisClusterTLS)
+		**out = **in
+	}
+	if in.PodTemplate != nil {
+		in, out := &in.PodTemplate, &out.PodTemplate
+		*out = new(RedisPodTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sentinel != nil {
+		in, out := &in.Sentinel, &out.Sentinel
+		*out = new(RedisSentinelSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UpdateStrategy != nil {
+		in, out := &in.UpdateStrategy, &out.UpdateStrategy
+		*out = new(RedisClusterUpdateStrategy)
+		**out = **in
+	}
+	if in.ReplicasPerMaster != nil {
+		in, out := &in.ReplicasPerMaster, &out.ReplicasPerMaster
+		*out = new(int32)
+		**out = **in
+	}
 	return
 }
 
@@ -94,7 +295,42 @@ func (in *RedisClusterSpec) DeepCopy() *RedisClusterSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RedisClusterStatus) DeepCopyInto(out *RedisClusterStatus) {
 	*out = *in
-	in.StatefulSetStatus.DeepCopyInto(&out.StatefulSetStatus)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]RedisClusterCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]RedisClusterNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastAppliedSpec != nil {
+		in, out := &in.LastAppliedSpec, &out.LastAppliedSpec
+		*out = new(RedisClusterSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Operation != nil {
+		in, out := &in.Operation, &out.Operation
+		*out = new(RedisClusterOperation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]RedisClusterOperation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = new(RedisClusterPlan)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -107,3 +343,122 @@ func (in *RedisClusterStatus) DeepCopy() *RedisClusterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterTLS) DeepCopyInto(out *RedisClusterTLS) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterTLS.
+func (in *RedisClusterTLS) DeepCopy() *RedisClusterTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisClusterUpdateStrategy) DeepCopyInto(out *RedisClusterUpdateStrategy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisClusterUpdateStrategy.
+func (in *RedisClusterUpdateStrategy) DeepCopy() *RedisClusterUpdateStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisClusterUpdateStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisPodTemplate) DeepCopyInto(out *RedisPodTemplate) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(v1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(v1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisPodTemplate.
+func (in *RedisPodTemplate) DeepCopy() *RedisPodTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisPodTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisSentinelSpec) DeepCopyInto(out *RedisSentinelSpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Quorum != nil {
+		in, out := &in.Quorum, &out.Quorum
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisSentinelSpec.
+func (in *RedisSentinelSpec) DeepCopy() *RedisSentinelSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisSentinelSpec)
+	in.DeepCopyInto(out)
+	return out
+}
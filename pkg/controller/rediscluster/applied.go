@@ -0,0 +1,125 @@
+package rediscluster
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+//把已经生效到集群上的spec记录到status.lastAppliedSpec
+//和当前的spec完全一致时，observedGeneration更新为当前的generation
+func (r *ReconcileRedisCluster) saveAppliedSpec(instance *crdv1alpha1.RedisCluster, applied *crdv1alpha1.RedisClusterSpec) error {
+	observedGeneration := instance.Status.ObservedGeneration
+	if reflect.DeepEqual(*applied, instance.Spec) {
+		observedGeneration = instance.Generation
+	}
+	if reflect.DeepEqual(instance.Status.LastAppliedSpec, applied) &&
+		instance.Status.ObservedGeneration == observedGeneration {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1alpha1.RedisCluster{}
+		err := r.client.Get(context.TODO(),
+			types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, latest)
+		if err != nil {
+			return err
+		}
+		latest.Status.LastAppliedSpec = applied.DeepCopy()
+		latest.Status.ObservedGeneration = observedGeneration
+		if err := r.client.Status().Update(context.TODO(), latest); err != nil {
+			return err
+		}
+		instance.Status.LastAppliedSpec = applied.DeepCopy()
+		instance.Status.ObservedGeneration = observedGeneration
+		return nil
+	})
+}
+
+//集群已经存在时，和spec比较的基准
+//status里还没有记录的时候(创建之后记录失败)，按statefulset的副本数推算
+func appliedSpec(instance *crdv1alpha1.RedisCluster, found *appsv1.StatefulSet) *crdv1alpha1.RedisClusterSpec {
+	if instance.Status.LastAppliedSpec != nil {
+		return instance.Status.LastAppliedSpec
+	}
+	return appliedSpecFromStatefulSet(instance, found)
+}
+
+func appliedSpecFromStatefulSet(instance *crdv1alpha1.RedisCluster, found *appsv1.StatefulSet) *crdv1alpha1.RedisClusterSpec {
+	applied := instance.Spec.DeepCopy()
+	if found.Spec.Replicas != nil {
+		shards := *found.Spec.Replicas / (applied.GetReplicasPerShard() + 1)
+		applied.Shards = &shards
+		applied.Replicas = nil
+	}
+	return applied
+}
+
+//老版本operator把处理过的spec记录在crd.xzbc.com.cn/spec这个annotation上
+//迁移到status.lastAppliedSpec之后只删除这一个annotation，用户和其他工具加的annotation保留
+func (r *ReconcileRedisCluster) migrateLegacyAnnotation(instance *crdv1alpha1.RedisCluster, found *appsv1.StatefulSet) error {
+	data, ok := instance.Annotations[crdv1alpha1.LegacySpecAnnotation]
+	if !ok {
+		return nil
+	}
+
+	if instance.Status.LastAppliedSpec == nil {
+		applied, err := toSpec(data)
+		if err != nil {
+			//annotation被改坏了，只能按statefulset的副本数推算集群的规模
+			log.Error(err, "Cannot parse the legacy spec annotation",
+				"Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+			r.recorder.Eventf(instance, corev1.EventTypeWarning, eventInvalidSpec,
+				"Cannot parse annotation %s, using the size of StatefulSet %s: %v",
+				crdv1alpha1.LegacySpecAnnotation, found.Name, err)
+			applied = appliedSpecFromStatefulSet(instance, found)
+		} else if applied.IsLegacySizing() {
+			//只有replicas的老的spec，换算成shards和replicasPerShard，避免被当成规格发生了变化
+			shards := applied.GetShards()
+			replicasPerShard := applied.GetReplicasPerShard()
+			applied.Shards = &shards
+			applied.ReplicasPerShard = &replicasPerShard
+			applied.Replicas = nil
+			applied.ReplicasPerMaster = nil
+		}
+		if err := r.saveAppliedSpec(instance, applied); err != nil {
+			return err
+		}
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1alpha1.RedisCluster{}
+		err := r.client.Get(context.TODO(),
+			types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, latest)
+		if err != nil {
+			return err
+		}
+		if _, ok := latest.Annotations[crdv1alpha1.LegacySpecAnnotation]; !ok {
+			return nil
+		}
+		delete(latest.Annotations, crdv1alpha1.LegacySpecAnnotation)
+		return r.client.Update(context.TODO(), latest)
+	})
+	if err != nil {
+		return err
+	}
+	delete(instance.Annotations, crdv1alpha1.LegacySpecAnnotation)
+	log.Info("Migrated the legacy spec annotation to status.lastAppliedSpec",
+		"Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+	return nil
+}
+
+func toSpec(data string) (*crdv1alpha1.RedisClusterSpec, error) {
+	redisClusterSpec := &crdv1alpha1.RedisClusterSpec{}
+	if err := json.Unmarshal([]byte(data), redisClusterSpec); err != nil {
+		return nil, err
+	}
+	return redisClusterSpec, nil
+}
@@ -0,0 +1,198 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/secret"
+	"xzbc-redis-cluster/pkg/resources/utils/redisclient"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//读取spec.auth引用的secret中的密码，没有开启认证的时候返回空字符串
+func (r *ReconcileRedisCluster) desiredPassword(instance *crdv1alpha1.RedisCluster) (string, error) {
+	if !instance.Spec.AuthEnabled() {
+		return "", nil
+	}
+	authSecret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(),
+		types.NamespacedName{Name: instance.Spec.Auth.SecretRef.Name, Namespace: instance.Namespace}, authSecret)
+	if err != nil {
+		return "", err
+	}
+	password, ok := authSecret.Data[instance.Spec.GetAuthSecretKey()]
+	if !ok || len(password) == 0 {
+		return "", fmt.Errorf("secret %s has no key %s", authSecret.Name, instance.Spec.GetAuthSecretKey())
+	}
+	return string(password), nil
+}
+
+//读取当前已经设置到redis节点上的密码，applied secret不存在说明节点上没有密码
+func (r *ReconcileRedisCluster) appliedPassword(instance *crdv1alpha1.RedisCluster) (*corev1.Secret, string, error) {
+	applied := &corev1.Secret{}
+	err := r.client.Get(context.TODO(),
+		types.NamespacedName{Name: secret.AppliedAuthName(instance), Namespace: instance.Namespace}, applied)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	return applied, string(applied.Data[secret.AppliedPasswordKey]), nil
+}
+
+//operator连接redis节点时使用的参数，优先使用已经生效的密码
+func (r *ReconcileRedisCluster) redisOptions(instance *crdv1alpha1.RedisCluster) (redisclient.Options, error) {
+	_, password, err := r.appliedPassword(instance)
+	if err != nil {
+		return redisclient.Options{}, err
+	}
+	if len(password) == 0 {
+		password, err = r.desiredPassword(instance)
+		if err != nil {
+			return redisclient.Options{}, err
+		}
+	}
+	options := redisclient.Options{Password: password}
+	if err := r.tlsConfig(instance, &options); err != nil {
+		return redisclient.Options{}, err
+	}
+	return options, nil
+}
+
+//把spec.auth中的密码同步到所有redis节点上
+//新建的集群pod启动时就从secret中读取了密码，只需要记录applied secret
+//已经运行的集群修改了密码，先修改masterauth再修改requirepass，保证slave在整个过程中都能连上master
+func (r *ReconcileRedisCluster) reconcileAuth(instance *crdv1alpha1.RedisCluster, clusterCreated bool) error {
+	desired, err := r.desiredPassword(instance)
+	if err != nil {
+		return err
+	}
+	applied, current, err := r.appliedPassword(instance)
+	if err != nil {
+		return err
+	}
+	if applied != nil && current == desired {
+		return nil
+	}
+	if applied == nil && len(desired) == 0 {
+		return nil
+	}
+
+	if clusterCreated {
+		options, err := r.redisOptions(instance)
+		if err != nil {
+			return err
+		}
+		pods := &corev1.PodList{}
+		err = r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+			client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods.Items {
+			if len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+				continue
+			}
+			if err := setNodePassword(nodeAddr(instance, pod.Status.PodIP), options, current, desired); err != nil {
+				return fmt.Errorf("failed to change password of pod %s: %v", pod.Name, err)
+			}
+		}
+		//主从模式下sentinel也需要用新密码连接master
+		if !instance.Spec.IsClusterMode() {
+			if err := r.setSentinelPassword(instance, desired); err != nil {
+				return err
+			}
+		}
+	}
+
+	if clusterCreated {
+		r.recorder.Event(instance, corev1.EventTypeNormal, eventPasswordChanged,
+			"Changed the password of all redis nodes")
+	}
+
+	if len(desired) == 0 {
+		return r.client.Delete(context.TODO(), applied)
+	}
+	if applied == nil {
+		return r.client.Create(context.TODO(), secret.NewAppliedAuth(instance, desired))
+	}
+	applied.Data = map[string][]byte{secret.AppliedPasswordKey: []byte(desired)}
+	return r.client.Update(context.TODO(), applied)
+}
+
+//pod重建之后已经是新密码了，所以旧密码连不上的时候再用新密码试一次
+func setNodePassword(addr string, options redisclient.Options, current, desired string) error {
+	options.Password = current
+	c, err := redisclient.DialWithOptions(addr, options)
+	if err != nil {
+		options.Password = desired
+		c, err = redisclient.DialWithOptions(addr, options)
+		if err != nil {
+			return err
+		}
+	}
+	defer c.Close()
+
+	if err := c.ConfigSet("masterauth", desired); err != nil {
+		return err
+	}
+	return c.ConfigSet("requirepass", desired)
+}
+
+//SENTINEL SET修改sentinel连接master使用的密码，密码为空时清除auth-pass
+func (r *ReconcileRedisCluster) setSentinelPassword(instance *crdv1alpha1.RedisCluster, password string) error {
+	sentinels := &corev1.PodList{}
+	err := r.client.List(context.TODO(), sentinels, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{crdv1alpha1.SentinelLabel: instance.Name})
+	if err != nil {
+		return err
+	}
+	for _, pod := range sentinels.Items {
+		if len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+			continue
+		}
+		c, err := redisclient.Dial(fmt.Sprintf("%s:%d", pod.Status.PodIP, crdv1alpha1.SentinelPort))
+		if err != nil {
+			return fmt.Errorf("failed to change password of sentinel %s: %v", pod.Name, err)
+		}
+		_, err = c.Do("SENTINEL", "SET", instance.Name, "auth-pass", password)
+		c.Close()
+		if err != nil {
+			return fmt.Errorf("failed to change password of sentinel %s: %v", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+//auth和TLS的secret不属于RedisCluster，修改之后需要找到引用它的RedisCluster重新处理
+type authSecretMapper struct {
+	client client.Client
+}
+
+func (m *authSecretMapper) Map(obj handler.MapObject) []reconcile.Request {
+	redisClusters := &crdv1alpha1.RedisClusterList{}
+	err := m.client.List(context.TODO(), redisClusters, client.InNamespace(obj.Meta.GetNamespace()))
+	if err != nil {
+		log.Error(err, "Failed to list RedisClusters for secret", "Secret.Name", obj.Meta.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, item := range redisClusters.Items {
+		if (item.Spec.AuthEnabled() && item.Spec.Auth.SecretRef.Name == obj.Meta.GetName()) ||
+			(item.Spec.TLSEnabled() && item.Spec.TLS.SecretRef.Name == obj.Meta.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace},
+			})
+		}
+	}
+	return requests
+}
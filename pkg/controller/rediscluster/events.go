@@ -0,0 +1,33 @@
+package rediscluster
+
+import (
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+)
+
+//RedisCluster上的event的reason，kubectl describe rediscluster可以看到整个生命周期
+const (
+	eventCreated         = "Created"
+	eventRestored        = "Restored"
+	eventUpdateFailed    = "UpdateFailed"
+	eventInvalidSpec     = "InvalidSpec"
+	eventFailover        = "Failover"
+	eventPasswordChanged = "PasswordChanged"
+	eventCertRotated     = "CertificateRotated"
+	eventJobCancelled    = "JobCancelled"
+	eventVolumeDeleted   = "VolumeDeleted"
+	eventSnapshotCreated = "SnapshotCreated"
+	eventSnapshotFailed  = "SnapshotFailed"
+	eventPaused          = "Paused"
+	eventResumed         = "Resumed"
+	eventPlanCreated     = "PlanCreated"
+	eventPlanApproved    = "PlanApproved"
+)
+
+//每种操作开始、完成和失败时的reason
+var operationEvents = map[crdv1alpha1.OperationType]struct {
+	started, finished, failed string
+}{
+	crdv1alpha1.OperationCreate:    {"BootstrapStarted", "BootstrapFinished", "BootstrapFailed"},
+	crdv1alpha1.OperationScaleUp:   {"ScaleUpStarted", "ScaleUpFinished", "ScaleUpFailed"},
+	crdv1alpha1.OperationScaleDown: {"ScaleDownStarted", "ScaleDownFinished", "ScaleDownFailed"},
+}
@@ -0,0 +1,230 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/utils/clusteradmin"
+	"xzbc-redis-cluster/pkg/resources/utils/rebalance"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	//operator自己执行时，命令已经发出、等待集群收敛的一步多久之后再检查一次
+	operatorPollInterval = 2 * time.Second
+	//每次Reconcile最多迁移的slot个数，避免长时间占用worker
+	operatorMigrateSlots = 256
+)
+
+//operator执行每一步用到的clusteradmin.Admin的方法
+//每次调用只发出还没有完成的命令，或者检查一次集群是否已经收敛，不会在Reconcile里等待
+type clusterAdmin interface {
+	CreateStep(ips []string, replicasPerMaster int) (bool, error)
+	JoinStep(ips []string, from, to, replicasPerMaster int) (bool, error)
+	RebalancePlan(ips []string, size int) (*rebalance.Plan, error)
+	MoveSlots(sourceIP, targetIP string, slots []int) error
+	Consistent(ips []string) (bool, error)
+	RemoveStep(ips []string, size int) (bool, error)
+	Close()
+}
+
+var _ clusterAdmin = &clusteradmin.Admin{}
+
+//spec.updateStrategy.executor为Operator时，operator直接连接redis pod执行创建集群和扩缩容
+//每次Reconcile执行一步，进度记录在status.operation里，每一步都可以在operator重启之后重新执行
+//一步的命令发出之后不等待集群收敛，阶段不变，过operatorPollInterval之后的Reconcile再检查一次
+//创建：WaitingForNodes -> JoiningNodes -> Done
+//扩容：WaitingForNodes -> JoiningNodes -> MigratingSlots -> Done
+//缩容：WaitingForNodes -> MigratingSlots -> RemovingNodes -> SlotsDrained，之后和job执行的缩容相同
+func (r *ReconcileRedisCluster) reconcileOperatorStep(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	//缩容时要删除的节点也要参与迁移
+	size := op.ToSize
+	if op.FromSize > size {
+		size = op.FromSize
+	}
+	ips, err := r.readyNodeIPs(instance, size)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if ips == nil {
+		if time.Since(op.LastTransitionTime.Time) > operationJobTimeout {
+			return r.failOperation(instance, op, fmt.Sprintf("timed out waiting for %d pods to become ready", size))
+		}
+		return reconcile.Result{RequeueAfter: operationPollInterval}, nil
+	}
+
+	if op.Phase == crdv1alpha1.OperationWaitingForNodes {
+		if op.Type == crdv1alpha1.OperationScaleDown {
+			return r.setOperationPhase(instance, op, crdv1alpha1.OperationMigratingSlots, "")
+		}
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationJoiningNodes, "")
+	}
+
+	admin, err := r.newAdmin(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	defer admin.Close()
+	replicasPerShard := int(instance.Spec.GetReplicasPerShard())
+
+	switch op.Phase {
+	case crdv1alpha1.OperationJoiningNodes:
+		if op.Type == crdv1alpha1.OperationCreate {
+			done, err := admin.CreateStep(ips, replicasPerShard)
+			if err != nil {
+				return r.retryOperatorStep(instance, op, err)
+			}
+			if !done {
+				return r.waitOperatorStep(instance, op, "the cluster to be created")
+			}
+			reqLogger.Info("Cluster created", "size", op.ToSize)
+			return r.setOperationPhase(instance, op, crdv1alpha1.OperationDone, "")
+		}
+		done, err := admin.JoinStep(ips, int(op.FromSize), int(op.ToSize), replicasPerShard)
+		if err != nil {
+			return r.retryOperatorStep(instance, op, err)
+		}
+		if !done {
+			return r.waitOperatorStep(instance, op, "the new nodes to join the cluster")
+		}
+		reqLogger.Info("New nodes joined the cluster", "from", op.FromSize, "to", op.ToSize)
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationMigratingSlots, "")
+
+	case crdv1alpha1.OperationMigratingSlots:
+		//每次都按当前的拓扑重新计算计划，上一次中断的迁移会包含在新的计划里
+		plan, err := admin.RebalancePlan(ips, int(op.ToSize))
+		if err != nil {
+			return r.retryOperatorStep(instance, op, err)
+		}
+		if len(plan.Moves) > 0 {
+			move := plan.Moves[0]
+			slots := move.SlotList()
+			if len(slots) > operatorMigrateSlots {
+				slots = slots[:operatorMigrateSlots]
+			}
+			if err := admin.MoveSlots(move.Source, move.Target, slots); err != nil {
+				return r.retryOperatorStep(instance, op, err)
+			}
+			//有进展时刷新LastTransitionTime，超时只计算没有进展的时间
+			op.Slots += int32(len(slots))
+			op.Message = fmt.Sprintf("%d slots left to migrate", plan.SlotCount()-len(slots))
+			op.LastTransitionTime = metav1.Now()
+			if err := r.saveOperation(instance, op); err != nil {
+				return reconcile.Result{}, err
+			}
+			return reconcile.Result{Requeue: true}, nil
+		}
+		if done, err := admin.Consistent(ips[:op.ToSize]); err != nil {
+			return r.retryOperatorStep(instance, op, err)
+		} else if !done {
+			return r.waitOperatorStep(instance, op, "the cluster to become consistent")
+		}
+		if op.Type == crdv1alpha1.OperationScaleDown {
+			return r.setOperationPhase(instance, op, crdv1alpha1.OperationRemovingNodes, "")
+		}
+		if err := r.recordAppliedSize(instance, op.ToSize); err != nil {
+			return reconcile.Result{}, err
+		}
+		reqLogger.Info("Scale-up finished", "from", op.FromSize, "to", op.ToSize, "slots", op.Slots)
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationDone, "")
+
+	case crdv1alpha1.OperationRemovingNodes:
+		//RemoveStep在被移除的master还有slot时返回错误，完成时被移除的节点已经不在集群里了
+		//再加上剩下的节点都认为16384个slot已经分配，和job执行时的verifySlotsDrained检查的是同样的条件
+		done, err := admin.RemoveStep(ips, int(op.ToSize))
+		if err != nil {
+			return r.retryOperatorStep(instance, op, err)
+		}
+		if !done {
+			return r.waitOperatorStep(instance, op, "the removed nodes to leave the cluster")
+		}
+		if done, err := admin.Consistent(ips[:op.ToSize]); err != nil {
+			return r.retryOperatorStep(instance, op, err)
+		} else if !done {
+			return r.waitOperatorStep(instance, op, "the cluster to become consistent")
+		}
+		reqLogger.Info("Slots drained from the removed nodes", "slots", op.Slots)
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationSlotsDrained, "")
+	}
+	return r.failOperation(instance, op, "unknown operation phase "+string(op.Phase))
+}
+
+//一步失败时不马上让操作失败，节点重启、握手还没有完成都会让一步暂时失败
+//同一个阶段超过operationJobTimeout没有进展时才进入Failed
+func (r *ReconcileRedisCluster) retryOperatorStep(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation, stepErr error) (reconcile.Result, error) {
+	if time.Since(op.LastTransitionTime.Time) > operationJobTimeout {
+		return r.failOperation(instance, op, stepErr.Error())
+	}
+	log.Info("Operation step failed, retrying", "Request.Namespace", instance.Namespace,
+		"Request.Name", instance.Name, "type", op.Type, "phase", op.Phase, "error", stepErr.Error())
+	return reconcile.Result{RequeueAfter: operationPollInterval}, nil
+}
+
+//一步的命令已经发出，集群还没有收敛，等待的内容记录在status.operation.message里
+//同一个阶段超过operationJobTimeout没有进展时进入Failed
+func (r *ReconcileRedisCluster) waitOperatorStep(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation, what string) (reconcile.Result, error) {
+	if time.Since(op.LastTransitionTime.Time) > operationJobTimeout {
+		return r.failOperation(instance, op, "timed out waiting for "+what)
+	}
+	message := "waiting for " + what
+	if op.Message != message {
+		op.Message = message
+		if err := r.saveOperation(instance, op); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{RequeueAfter: operatorPollInterval}, nil
+}
+
+//连接redis节点用的Admin，用完之后要Close
+func (r *ReconcileRedisCluster) newAdmin(instance *crdv1alpha1.RedisCluster) (clusterAdmin, error) {
+	if r.newClusterAdmin != nil {
+		return r.newClusterAdmin(instance)
+	}
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		return nil, err
+	}
+	admin := clusteradmin.New(int(instance.Spec.GetRedisPort()), options)
+	admin.Logf = func(format string, args ...interface{}) {
+		log.Info(fmt.Sprintf(format, args...), "Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+	}
+	return admin, nil
+}
+
+//按序号排列的前size个pod的ip，有pod还没有就绪时返回nil
+func (r *ReconcileRedisCluster) readyNodeIPs(instance *crdv1alpha1.RedisCluster, size int32) ([]string, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, size)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := podOrdinal(pod.Name)
+		if ordinal < 0 || ordinal >= int(size) || pod.DeletionTimestamp != nil ||
+			len(pod.Status.PodIP) == 0 || !podReady(pod) {
+			continue
+		}
+		ips[ordinal] = pod.Status.PodIP
+	}
+	for _, ip := range ips {
+		if len(ip) == 0 {
+			return nil, nil
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,331 @@
+package rediscluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"xzbc-redis-cluster/pkg/apis"
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/utils/rebalance"
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//每个方法只返回设置好的结果，记录调用的顺序
+type fakeAdmin struct {
+	//CreateStep、JoinStep、RemoveStep的结果
+	done bool
+	//Consistent的结果
+	consistent bool
+	plan       *rebalance.Plan
+	err        error
+	calls      []string
+	//MoveSlots迁移的slot个数
+	moved int
+}
+
+func (a *fakeAdmin) CreateStep(ips []string, replicasPerMaster int) (bool, error) {
+	a.calls = append(a.calls, fmt.Sprintf("CreateStep(%d, %d)", len(ips), replicasPerMaster))
+	return a.done, a.err
+}
+
+func (a *fakeAdmin) JoinStep(ips []string, from, to, replicasPerMaster int) (bool, error) {
+	a.calls = append(a.calls, fmt.Sprintf("JoinStep(%d, %d, %d)", from, to, replicasPerMaster))
+	return a.done, a.err
+}
+
+func (a *fakeAdmin) RebalancePlan(ips []string, size int) (*rebalance.Plan, error) {
+	a.calls = append(a.calls, fmt.Sprintf("RebalancePlan(%d)", size))
+	if a.plan == nil {
+		return &rebalance.Plan{}, a.err
+	}
+	return a.plan, a.err
+}
+
+func (a *fakeAdmin) MoveSlots(sourceIP, targetIP string, slots []int) error {
+	a.calls = append(a.calls, fmt.Sprintf("MoveSlots(%s, %s)", sourceIP, targetIP))
+	a.moved += len(slots)
+	return a.err
+}
+
+func (a *fakeAdmin) Consistent(ips []string) (bool, error) {
+	a.calls = append(a.calls, fmt.Sprintf("Consistent(%d)", len(ips)))
+	return a.consistent, a.err
+}
+
+func (a *fakeAdmin) RemoveStep(ips []string, size int) (bool, error) {
+	a.calls = append(a.calls, fmt.Sprintf("RemoveStep(%d)", size))
+	return a.done, a.err
+}
+
+func (a *fakeAdmin) Close() {}
+
+//status.operation里记录着op的RedisCluster，以及序号小于pods的就绪pod
+func newOperatorReconciler(t *testing.T, op *crdv1alpha1.RedisClusterOperation, pods int,
+	admin *fakeAdmin) (*ReconcileRedisCluster, *crdv1alpha1.RedisCluster) {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+
+	replicasPerShard := int32(1)
+	instance := &crdv1alpha1.RedisCluster{}
+	instance.Name = "redis"
+	instance.Namespace = "default"
+	instance.Spec.ReplicasPerShard = &replicasPerShard
+	instance.Status.Operation = op
+	objs := []runtime.Object{instance.DeepCopy()}
+	for i := 0; i < pods; i++ {
+		pod := &corev1.Pod{}
+		pod.Name = fmt.Sprintf("redis-%d", i)
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{"crd.xzbc.com.cn/v1alpha1": instance.Name}
+		pod.Status.PodIP = fmt.Sprintf("10.0.0.%d", i)
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		objs = append(objs, pod)
+	}
+
+	r := &ReconcileRedisCluster{
+		client:   fake.NewFakeClientWithScheme(s, objs...),
+		scheme:   s,
+		recorder: record.NewFakeRecorder(10),
+		newClusterAdmin: func(*crdv1alpha1.RedisCluster) (clusterAdmin, error) {
+			return admin, nil
+		},
+	}
+	return r, instance
+}
+
+func newTestOperation(opType crdv1alpha1.OperationType, phase crdv1alpha1.OperationPhase,
+	from, to int32) *crdv1alpha1.RedisClusterOperation {
+	now := metav1.Now()
+	return &crdv1alpha1.RedisClusterOperation{
+		Type:               opType,
+		Phase:              phase,
+		Executor:           crdv1alpha1.ExecutorOperator,
+		FromSize:           from,
+		ToSize:             to,
+		StartTime:          now,
+		LastTransitionTime: now,
+	}
+}
+
+func TestReconcileOperatorStep(t *testing.T) {
+	//一个master迁出的slot比每次Reconcile最多迁移的多
+	bigMove := &rebalance.Plan{Moves: []rebalance.Move{
+		{Source: "10.0.0.4", Target: "10.0.0.0", Slots: []topology.SlotRange{{Start: 0, End: 999}}},
+	}}
+
+	tests := []struct {
+		name  string
+		op    *crdv1alpha1.RedisClusterOperation
+		pods  int
+		admin *fakeAdmin
+		//阶段已经没有进展的时间
+		stalled   time.Duration
+		wantPhase crdv1alpha1.OperationPhase
+		wantCalls []string
+		want      reconcile.Result
+		//status.operation.message
+		wantMessage string
+		wantSlots   int32
+	}{
+		{
+			name:      "waiting for pods",
+			op:        newTestOperation(crdv1alpha1.OperationCreate, crdv1alpha1.OperationWaitingForNodes, 0, 6),
+			pods:      5,
+			admin:     &fakeAdmin{},
+			wantPhase: crdv1alpha1.OperationWaitingForNodes,
+			want:      reconcile.Result{RequeueAfter: operationPollInterval},
+		},
+		{
+			name:      "pods ready for create",
+			op:        newTestOperation(crdv1alpha1.OperationCreate, crdv1alpha1.OperationWaitingForNodes, 0, 6),
+			pods:      6,
+			admin:     &fakeAdmin{},
+			wantPhase: crdv1alpha1.OperationJoiningNodes,
+		},
+		{
+			name:      "pods ready for scale-down",
+			op:        newTestOperation(crdv1alpha1.OperationScaleDown, crdv1alpha1.OperationWaitingForNodes, 6, 4),
+			pods:      6,
+			admin:     &fakeAdmin{},
+			wantPhase: crdv1alpha1.OperationMigratingSlots,
+		},
+		{
+			name:        "create not finished",
+			op:          newTestOperation(crdv1alpha1.OperationCreate, crdv1alpha1.OperationJoiningNodes, 0, 6),
+			pods:        6,
+			admin:       &fakeAdmin{},
+			wantPhase:   crdv1alpha1.OperationJoiningNodes,
+			wantCalls:   []string{"CreateStep(6, 1)"},
+			want:        reconcile.Result{RequeueAfter: operatorPollInterval},
+			wantMessage: "waiting for the cluster to be created",
+		},
+		{
+			name:      "create finished",
+			op:        newTestOperation(crdv1alpha1.OperationCreate, crdv1alpha1.OperationJoiningNodes, 0, 6),
+			pods:      6,
+			admin:     &fakeAdmin{done: true},
+			wantPhase: crdv1alpha1.OperationDone,
+			wantCalls: []string{"CreateStep(6, 1)"},
+		},
+		{
+			name:      "create step failed",
+			op:        newTestOperation(crdv1alpha1.OperationCreate, crdv1alpha1.OperationJoiningNodes, 0, 6),
+			pods:      6,
+			admin:     &fakeAdmin{err: errors.New("connection refused")},
+			wantPhase: crdv1alpha1.OperationJoiningNodes,
+			wantCalls: []string{"CreateStep(6, 1)"},
+			want:      reconcile.Result{RequeueAfter: operationPollInterval},
+		},
+		{
+			name:        "create timed out",
+			op:          newTestOperation(crdv1alpha1.OperationCreate, crdv1alpha1.OperationJoiningNodes, 0, 6),
+			pods:        6,
+			admin:       &fakeAdmin{},
+			stalled:     operationJobTimeout + time.Minute,
+			wantPhase:   crdv1alpha1.OperationFailed,
+			wantCalls:   []string{"CreateStep(6, 1)"},
+			wantMessage: "timed out waiting for the cluster to be created",
+		},
+		{
+			name:        "join not finished",
+			op:          newTestOperation(crdv1alpha1.OperationScaleUp, crdv1alpha1.OperationJoiningNodes, 4, 6),
+			pods:        6,
+			admin:       &fakeAdmin{},
+			wantPhase:   crdv1alpha1.OperationJoiningNodes,
+			wantCalls:   []string{"JoinStep(4, 6, 1)"},
+			want:        reconcile.Result{RequeueAfter: operatorPollInterval},
+			wantMessage: "waiting for the new nodes to join the cluster",
+		},
+		{
+			name:      "join finished",
+			op:        newTestOperation(crdv1alpha1.OperationScaleUp, crdv1alpha1.OperationJoiningNodes, 4, 6),
+			pods:      6,
+			admin:     &fakeAdmin{done: true},
+			wantPhase: crdv1alpha1.OperationMigratingSlots,
+			wantCalls: []string{"JoinStep(4, 6, 1)"},
+		},
+		{
+			name:        "migrate a batch of slots",
+			op:          newTestOperation(crdv1alpha1.OperationScaleDown, crdv1alpha1.OperationMigratingSlots, 6, 4),
+			pods:        6,
+			admin:       &fakeAdmin{plan: bigMove},
+			wantPhase:   crdv1alpha1.OperationMigratingSlots,
+			wantCalls:   []string{"RebalancePlan(4)", "MoveSlots(10.0.0.4, 10.0.0.0)"},
+			want:        reconcile.Result{Requeue: true},
+			wantMessage: fmt.Sprintf("%d slots left to migrate", 1000-operatorMigrateSlots),
+			wantSlots:   operatorMigrateSlots,
+		},
+		{
+			name:        "migrated but not consistent",
+			op:          newTestOperation(crdv1alpha1.OperationScaleUp, crdv1alpha1.OperationMigratingSlots, 4, 6),
+			pods:        6,
+			admin:       &fakeAdmin{},
+			wantPhase:   crdv1alpha1.OperationMigratingSlots,
+			wantCalls:   []string{"RebalancePlan(6)", "Consistent(6)"},
+			want:        reconcile.Result{RequeueAfter: operatorPollInterval},
+			wantMessage: "waiting for the cluster to become consistent",
+		},
+		{
+			name:      "scale-up finished",
+			op:        newTestOperation(crdv1alpha1.OperationScaleUp, crdv1alpha1.OperationMigratingSlots, 4, 6),
+			pods:      6,
+			admin:     &fakeAdmin{consistent: true},
+			wantPhase: crdv1alpha1.OperationDone,
+			wantCalls: []string{"RebalancePlan(6)", "Consistent(6)"},
+		},
+		{
+			name:      "scale-down slots migrated",
+			op:        newTestOperation(crdv1alpha1.OperationScaleDown, crdv1alpha1.OperationMigratingSlots, 6, 4),
+			pods:      6,
+			admin:     &fakeAdmin{consistent: true},
+			wantPhase: crdv1alpha1.OperationRemovingNodes,
+			wantCalls: []string{"RebalancePlan(4)", "Consistent(4)"},
+		},
+		{
+			name:        "removing nodes",
+			op:          newTestOperation(crdv1alpha1.OperationScaleDown, crdv1alpha1.OperationRemovingNodes, 6, 4),
+			pods:        6,
+			admin:       &fakeAdmin{consistent: true},
+			wantPhase:   crdv1alpha1.OperationRemovingNodes,
+			wantCalls:   []string{"RemoveStep(4)"},
+			want:        reconcile.Result{RequeueAfter: operatorPollInterval},
+			wantMessage: "waiting for the removed nodes to leave the cluster",
+		},
+		{
+			name:        "nodes removed but not consistent",
+			op:          newTestOperation(crdv1alpha1.OperationScaleDown, crdv1alpha1.OperationRemovingNodes, 6, 4),
+			pods:        6,
+			admin:       &fakeAdmin{done: true},
+			wantPhase:   crdv1alpha1.OperationRemovingNodes,
+			wantCalls:   []string{"RemoveStep(4)", "Consistent(4)"},
+			want:        reconcile.Result{RequeueAfter: operatorPollInterval},
+			wantMessage: "waiting for the cluster to become consistent",
+		},
+		{
+			name:      "nodes removed",
+			op:        newTestOperation(crdv1alpha1.OperationScaleDown, crdv1alpha1.OperationRemovingNodes, 6, 4),
+			pods:      6,
+			admin:     &fakeAdmin{done: true, consistent: true},
+			wantPhase: crdv1alpha1.OperationSlotsDrained,
+			wantCalls: []string{"RemoveStep(4)", "Consistent(4)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.op.LastTransitionTime = metav1.NewTime(time.Now().Add(-test.stalled))
+			r, instance := newOperatorReconciler(t, test.op, test.pods, test.admin)
+
+			start := time.Now()
+			result, err := r.reconcileOperatorStep(instance, test.op.DeepCopy())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			//每一步只发出命令、检查一次，不在Reconcile里等待
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("step blocked the worker for %v", elapsed)
+			}
+			if result != test.want {
+				t.Errorf("result = %+v, want %+v", result, test.want)
+			}
+			if !reflect.DeepEqual(test.admin.calls, test.wantCalls) {
+				t.Errorf("calls = %v, want %v", test.admin.calls, test.wantCalls)
+			}
+
+			saved := &crdv1alpha1.RedisCluster{}
+			err = r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, saved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			op := saved.Status.Operation
+			if op.Phase != test.wantPhase {
+				t.Errorf("phase = %s, want %s", op.Phase, test.wantPhase)
+			}
+			if op.Message != test.wantMessage {
+				t.Errorf("message = %q, want %q", op.Message, test.wantMessage)
+			}
+			if op.Slots != test.wantSlots || test.admin.moved != int(test.wantSlots) {
+				t.Errorf("%d slots recorded and %d moved, want %d", op.Slots, test.admin.moved, test.wantSlots)
+			}
+		})
+	}
+}
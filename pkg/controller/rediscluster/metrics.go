@@ -0,0 +1,110 @@
+package rediscluster
+
+import (
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+//注册到controller-runtime的metrics.Registry，和controller-runtime自己的指标一起由manager的metrics端口提供
+//operator部署时创建的ServiceMonitor会采集这个端口
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "rediscluster_reconcile_duration_seconds",
+		Help: "Time spent reconciling a RedisCluster",
+	}, []string{"namespace", "name"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rediscluster_reconcile_errors_total",
+		Help: "Number of reconciles of a RedisCluster that returned an error",
+	}, []string{"namespace", "name"})
+
+	operationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rediscluster_operations_total",
+		Help: "Number of finished create and scale operations by result",
+	}, []string{"namespace", "name", "type", "result"})
+
+	//扩缩容需要迁移数据，时间从几秒到几十分钟不等
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rediscluster_operation_duration_seconds",
+		Help:    "Duration of finished create and scale operations",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+	}, []string{"type", "result"})
+
+	slotsMigrated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rediscluster_slots_migrated_total",
+		Help: "Number of hash slots moved between nodes by scale operations",
+	}, []string{"namespace", "name"})
+
+	clusterPhase = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rediscluster_phase",
+		Help: "Current phase of a RedisCluster, 1 for the current phase and 0 for the others",
+	}, []string{"namespace", "name", "phase"})
+
+	jobFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rediscluster_job_failures_total",
+		Help: "Number of create and scale jobs that failed",
+	}, []string{"namespace", "name", "type"})
+)
+
+//操作的结果，用作rediscluster_operations_total的result label
+const (
+	resultSucceeded = "succeeded"
+	resultFailed    = "failed"
+)
+
+var allPhases = []crdv1alpha1.RedisClusterPhase{
+	crdv1alpha1.RedisClusterPhaseCreating,
+	crdv1alpha1.RedisClusterPhaseBootstrapping,
+	crdv1alpha1.RedisClusterPhaseReady,
+	crdv1alpha1.RedisClusterPhaseScaling,
+	crdv1alpha1.RedisClusterPhaseDegraded,
+	crdv1alpha1.RedisClusterPhaseFailed,
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		reconcileDuration,
+		reconcileErrors,
+		operationsTotal,
+		operationDuration,
+		slotsMigrated,
+		clusterPhase,
+		jobFailures,
+	)
+}
+
+//操作结束时记录次数、耗时和迁移的slot个数
+func recordOperation(instance *crdv1alpha1.RedisCluster, op *crdv1alpha1.RedisClusterOperation) {
+	result := resultSucceeded
+	if op.Phase == crdv1alpha1.OperationFailed {
+		result = resultFailed
+	}
+	operationsTotal.WithLabelValues(instance.Namespace, instance.Name, string(op.Type), result).Inc()
+	operationDuration.WithLabelValues(string(op.Type), result).
+		Observe(op.LastTransitionTime.Sub(op.StartTime.Time).Seconds())
+	if result == resultSucceeded && op.Slots > 0 {
+		slotsMigrated.WithLabelValues(instance.Namespace, instance.Name).Add(float64(op.Slots))
+	}
+}
+
+func recordPhase(instance *crdv1alpha1.RedisCluster, phase crdv1alpha1.RedisClusterPhase) {
+	for _, p := range allPhases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		clusterPhase.WithLabelValues(instance.Namespace, instance.Name, string(p)).Set(value)
+	}
+}
+
+//RedisCluster删除之后不再导出它的指标
+func forgetMetrics(instance *crdv1alpha1.RedisCluster) {
+	for _, p := range allPhases {
+		clusterPhase.DeleteLabelValues(instance.Namespace, instance.Name, string(p))
+	}
+	reconcileDuration.DeleteLabelValues(instance.Namespace, instance.Name)
+	reconcileErrors.DeleteLabelValues(instance.Namespace, instance.Name)
+	slotsMigrated.DeleteLabelValues(instance.Namespace, instance.Name)
+}
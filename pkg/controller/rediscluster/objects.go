@@ -0,0 +1,188 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+//configMap不存在就创建，存在就恢复成operator期望的内容
+//用户自己加的label和annotation会保留下来
+func (r *ReconcileRedisCluster) ensureConfigMap(instance *crdv1alpha1.RedisCluster) error {
+	cm := desiredConfigMap(instance)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &corev1.ConfigMap{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+		if err != nil && errors.IsNotFound(err) {
+			return r.createObject(instance, cm, "ConfigMap")
+		} else if err != nil {
+			return err
+		}
+
+		changed, err := adoptObject(instance, &found.ObjectMeta, &cm.ObjectMeta)
+		if err != nil {
+			return err
+		}
+		if mergeObjectMeta(&found.ObjectMeta, &cm.ObjectMeta) {
+			changed = true
+		}
+		if !reflect.DeepEqual(found.Data, cm.Data) {
+			found.Data = cm.Data
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		log.Info("Restoring ConfigMap", "ConfigMap.Namespace", found.Namespace, "ConfigMap.Name", found.Name)
+		return r.restoreObject(instance, found, "ConfigMap")
+	})
+}
+
+//service不存在就创建，端口、selector和类型被修改过就恢复
+//clusterIP创建之后不能修改，headless service和普通service之间的转换只能删除重建
+func (r *ReconcileRedisCluster) ensureService(instance *crdv1alpha1.RedisCluster, svc *corev1.Service) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &corev1.Service{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, found)
+		if err != nil && errors.IsNotFound(err) {
+			return r.createObject(instance, svc, "Service")
+		} else if err != nil {
+			return err
+		}
+
+		changed, err := adoptObject(instance, &found.ObjectMeta, &svc.ObjectMeta)
+		if err != nil {
+			return err
+		}
+
+		if (svc.Spec.ClusterIP == corev1.ClusterIPNone) != (found.Spec.ClusterIP == corev1.ClusterIPNone) {
+			log.Info("Recreating Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name,
+				"clusterIP", found.Spec.ClusterIP)
+			if err := r.client.Delete(context.TODO(), found); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			return r.createObject(instance, svc, "Service")
+		}
+
+		if mergeObjectMeta(&found.ObjectMeta, &svc.ObjectMeta) {
+			changed = true
+		}
+		//apiserver会填充protocol和targetPort，用DeepDerivative只比较期望的spec里设置了的字段
+		if len(found.Spec.Ports) != len(svc.Spec.Ports) ||
+			!equality.Semantic.DeepDerivative(svc.Spec.Ports, found.Spec.Ports) ||
+			!reflect.DeepEqual(found.Spec.Selector, svc.Spec.Selector) ||
+			serviceType(found) != serviceType(svc) {
+			found.Spec.Ports = svc.Spec.Ports
+			found.Spec.Selector = svc.Spec.Selector
+			found.Spec.Type = svc.Spec.Type
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		log.Info("Restoring Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+		return r.restoreObject(instance, found, "Service")
+	})
+}
+
+//集群模式的statefulset被手工修改之后恢复成spec生成的内容
+//扩缩容的时候replicas和spec不一致，只在没有正在进行的操作并且spec已经应用的时候调用
+func (r *ReconcileRedisCluster) repairStatefulSet(instance *crdv1alpha1.RedisCluster) error {
+	sts, err := r.desiredStatefulSet(instance)
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &appsv1.StatefulSet{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: sts.Name, Namespace: sts.Namespace}, found)
+		if err != nil {
+			return err
+		}
+		//apiserver会给statefulset填充默认值，用DeepDerivative只比较期望的spec里设置了的字段
+		if equality.Semantic.DeepDerivative(sts.Spec.Template, found.Spec.Template) &&
+			found.Spec.Replicas != nil && *found.Spec.Replicas == *sts.Spec.Replicas {
+			return nil
+		}
+		log.Info("Restoring StatefulSet", "StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
+		mergeStatefulSetSpec(found, sts.DeepCopy())
+		return r.restoreObject(instance, found, "StatefulSet")
+	})
+}
+
+func (r *ReconcileRedisCluster) createObject(instance *crdv1alpha1.RedisCluster, obj runtime.Object, kind string) error {
+	if err := r.client.Create(context.TODO(), obj); err != nil {
+		return err
+	}
+	if meta, err := apimeta.Accessor(obj); err == nil {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, eventCreated, "Created %s %s", kind, meta.GetName())
+	}
+	return nil
+}
+
+func (r *ReconcileRedisCluster) restoreObject(instance *crdv1alpha1.RedisCluster, obj runtime.Object, kind string) error {
+	if err := r.client.Update(context.TODO(), obj); err != nil {
+		return err
+	}
+	if meta, err := apimeta.Accessor(obj); err == nil {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, eventRestored,
+			"Restored the operator managed fields of %s %s", kind, meta.GetName())
+	}
+	return nil
+}
+
+func serviceType(svc *corev1.Service) corev1.ServiceType {
+	if len(svc.Spec.Type) == 0 {
+		return corev1.ServiceTypeClusterIP
+	}
+	return svc.Spec.Type
+}
+
+//对象已经存在但是没有controller时，把它接管过来
+//属于其他controller的同名对象不能修改，返回错误
+func adoptObject(instance *crdv1alpha1.RedisCluster, found, desired *metav1.ObjectMeta) (bool, error) {
+	owner := metav1.GetControllerOf(found)
+	if owner == nil {
+		found.OwnerReferences = append(found.OwnerReferences, desired.OwnerReferences...)
+		return len(desired.OwnerReferences) > 0, nil
+	}
+	if owner.UID != instance.UID {
+		return false, fmt.Errorf("%s already exists and is controlled by %s %s", found.Name, owner.Kind, owner.Name)
+	}
+	return false, nil
+}
+
+//只恢复operator设置的label和annotation，用户加的不会被删除
+func mergeObjectMeta(found, desired *metav1.ObjectMeta) bool {
+	changed := false
+	for key, value := range desired.Labels {
+		if current, ok := found.Labels[key]; !ok || current != value {
+			if found.Labels == nil {
+				found.Labels = map[string]string{}
+			}
+			found.Labels[key] = value
+			changed = true
+		}
+	}
+	for key, value := range desired.Annotations {
+		if current, ok := found.Annotations[key]; !ok || current != value {
+			if found.Annotations == nil {
+				found.Annotations = map[string]string{}
+			}
+			found.Annotations[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,344 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/job"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	//status.history里最多保留的操作个数
+	maxOperationHistory = 10
+	//create和scale job最长运行时间，超过之后操作进入Failed
+	//operator自己执行时是一个阶段没有进展的最长时间
+	operationJobTimeout = 30 * time.Minute
+	//job结束会通过Job的watch触发Reconcile，这里的间隔只用来检查超时
+	operationPollInterval = 10 * time.Second
+)
+
+//继续推进status.operation里正在进行的操作
+//操作结束之前不处理spec的其他变化，避免两个job同时修改同一个集群
+func (r *ReconcileRedisCluster) reconcileOperation(instance *crdv1alpha1.RedisCluster,
+	found *appsv1.StatefulSet) (reconcile.Result, error) {
+	op := instance.Status.Operation.DeepCopy()
+	//operator自己执行的阶段，缩容移出节点之后的阶段和job共用
+	switch op.Phase {
+	case crdv1alpha1.OperationWaitingForNodes, crdv1alpha1.OperationJoiningNodes,
+		crdv1alpha1.OperationMigratingSlots, crdv1alpha1.OperationRemovingNodes:
+		return r.reconcileOperatorStep(instance, op)
+	}
+	switch op.Type {
+	case crdv1alpha1.OperationCreate:
+		return r.reconcileCreate(instance, op)
+	case crdv1alpha1.OperationScaleUp:
+		return r.reconcileScaleUp(instance, op)
+	case crdv1alpha1.OperationScaleDown:
+		return r.reconcileScaleDown(instance, found, op)
+	}
+	return r.failOperation(instance, op, "unknown operation type "+string(op.Type))
+}
+
+//等待create job完成，集群创建之后status.lastAppliedSpec里已经记录了spec，这里只需要结束操作
+func (r *ReconcileRedisCluster) reconcileCreate(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation) (reconcile.Result, error) {
+	if finished, result, err := r.waitForJob(instance, op); !finished {
+		return result, err
+	}
+	log.Info("Cluster created", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name,
+		"size", op.ToSize)
+	return r.setOperationPhase(instance, op, crdv1alpha1.OperationDone, "")
+}
+
+//序号不小于fromOrdinal的pod上的slot个数，用来统计扩缩容迁移了多少slot
+func (r *ReconcileRedisCluster) slotsOnPods(instance *crdv1alpha1.RedisCluster, fromOrdinal int32) (int32, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+	if err != nil {
+		return 0, err
+	}
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		return 0, err
+	}
+	_, nodes, err := fetchTopology(instance, pods.Items, options)
+	if err != nil {
+		return 0, err
+	}
+	var slots int32
+	for _, node := range nodes {
+		if int32(podOrdinal(node.PodName)) < fromOrdinal {
+			continue
+		}
+		for _, slotRange := range node.Slots {
+			slots += countSlots(slotRange)
+		}
+	}
+	return slots, nil
+}
+
+//CLUSTER NODES里的slot是单个slot或者start-end的范围
+func countSlots(slotRange string) int32 {
+	bounds := strings.SplitN(slotRange, "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0
+	}
+	if len(bounds) == 1 {
+		return 1
+	}
+	end, err := strconv.Atoi(bounds[1])
+	if err != nil || end < start {
+		return 0
+	}
+	return int32(end - start + 1)
+}
+
+//检查operation的job，job还在运行时返回finished=false和等待用的Result
+//job失败、被删除或者超时时把operation标记为Failed，同样返回finished=false
+func (r *ReconcileRedisCluster) waitForJob(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation) (bool, reconcile.Result, error) {
+	opJob := &batchv1.Job{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: op.JobName, Namespace: instance.Namespace}, opJob)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			result, err := r.failOperation(instance, op, "job "+op.JobName+" was deleted")
+			return false, result, err
+		}
+		return false, reconcile.Result{}, err
+	}
+	switch {
+	case jobFailed(opJob):
+		jobFailures.WithLabelValues(instance.Namespace, instance.Name, string(op.Type)).Inc()
+		result, err := r.failOperation(instance, op, "job "+op.JobName+" failed")
+		return false, result, err
+	case !jobSucceeded(opJob):
+		//暂停恢复之后LastTransitionTime会被刷新，暂停的时间不算在超时里
+		if time.Since(op.LastTransitionTime.Time) > operationJobTimeout {
+			result, err := r.failOperation(instance, op, "timed out waiting for job "+op.JobName)
+			return false, result, err
+		}
+		return false, reconcile.Result{RequeueAfter: operationPollInterval}, nil
+	}
+	return true, reconcile.Result{}, nil
+}
+
+//status还没来得及写入的时候，可能已经有一个正在运行的同类job，找到它之后直接沿用
+func (r *ReconcileRedisCluster) findRunningJob(instance *crdv1alpha1.RedisCluster, jobType string) (*batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn": instance.Name, job.TypeLabel: jobType})
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		if !jobFinished(&jobs.Items[i]) {
+			return &jobs.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+//job的名字由操作的参数决定，同名的job已经存在时：
+//还在运行的就是这次操作的job，直接沿用；已经结束的是以前同样参数的操作留下的，删除之后下一次Reconcile重新创建
+func (r *ReconcileRedisCluster) createOperationJob(instance *crdv1alpha1.RedisCluster, opJob *batchv1.Job) error {
+	err := r.client.Create(context.TODO(), opJob)
+	if err == nil || !errors.IsAlreadyExists(err) {
+		return err
+	}
+	existing := &batchv1.Job{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: opJob.Name, Namespace: opJob.Namespace}, existing)
+	if err != nil {
+		return err
+	}
+	if existing.Labels["crd.xzbc.com.cn"] != instance.Name {
+		return fmt.Errorf("job %s already exists and does not belong to RedisCluster %s", existing.Name, instance.Name)
+	}
+	if existing.DeletionTimestamp == nil && !jobFinished(existing) {
+		existing.DeepCopyInto(opJob)
+		return nil
+	}
+	if existing.DeletionTimestamp == nil {
+		log.Info("Deleting finished job with the same name", "Job.Namespace", existing.Namespace, "Job.Name", existing.Name)
+		err := r.client.Delete(context.TODO(), existing, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("job %s from an earlier operation is being deleted", existing.Name)
+}
+
+//job已经创建好之后记录一个新的操作，进入JobCreated
+func (r *ReconcileRedisCluster) startOperation(instance *crdv1alpha1.RedisCluster, opType crdv1alpha1.OperationType,
+	fromSize, toSize int32, jobName string, slots int32) (reconcile.Result, error) {
+	op := newOperation(instance, opType, fromSize, toSize)
+	op.Phase = crdv1alpha1.OperationJobCreated
+	op.Executor = crdv1alpha1.ExecutorJob
+	op.JobName = jobName
+	op.Slots = slots
+	return r.beginOperation(instance, op)
+}
+
+//由operator自己执行的操作，从等待pod就绪开始
+func (r *ReconcileRedisCluster) startOperatorOperation(instance *crdv1alpha1.RedisCluster, opType crdv1alpha1.OperationType,
+	fromSize, toSize int32) (reconcile.Result, error) {
+	op := newOperation(instance, opType, fromSize, toSize)
+	op.Phase = crdv1alpha1.OperationWaitingForNodes
+	op.Executor = crdv1alpha1.ExecutorOperator
+	return r.beginOperation(instance, op)
+}
+
+func newOperation(instance *crdv1alpha1.RedisCluster, opType crdv1alpha1.OperationType,
+	fromSize, toSize int32) *crdv1alpha1.RedisClusterOperation {
+	now := metav1.Now()
+	return &crdv1alpha1.RedisClusterOperation{
+		Type:               opType,
+		FromSize:           fromSize,
+		ToSize:             toSize,
+		Plan:               approvedPlan(instance),
+		Generation:         instance.Generation,
+		StartTime:          now,
+		LastTransitionTime: now,
+	}
+}
+
+func (r *ReconcileRedisCluster) beginOperation(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation) (reconcile.Result, error) {
+	log.Info("Operation started", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name,
+		"type", op.Type, "from", op.FromSize, "to", op.ToSize, "executor", op.Executor, "job", op.JobName)
+	if err := r.saveOperation(instance, op); err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(op.JobName) > 0 {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, operationEvents[op.Type].started,
+			"Started %s from %d to %d pods, job %s", op.Type, op.FromSize, op.ToSize, op.JobName)
+	} else {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, operationEvents[op.Type].started,
+			"Started %s from %d to %d pods in the operator", op.Type, op.FromSize, op.ToSize)
+	}
+	return reconcile.Result{RequeueAfter: operationPollInterval}, nil
+}
+
+func (r *ReconcileRedisCluster) failOperation(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation, message string) (reconcile.Result, error) {
+	log.Info("Operation failed", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name,
+		"type", op.Type, "phase", op.Phase, "message", message)
+	return r.setOperationPhase(instance, op, crdv1alpha1.OperationFailed, message)
+}
+
+//暂停期间job可能还在运行，也可能已经结束，恢复之后从status.operation记录的阶段继续
+//刷新LastTransitionTime，避免暂停的时间被算成超时
+func (r *ReconcileRedisCluster) resume(instance *crdv1alpha1.RedisCluster) error {
+	log.Info("RedisCluster resumed", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+	r.recorder.Event(instance, corev1.EventTypeNormal, eventResumed, "Reconciliation resumed")
+	op := instance.Status.Operation
+	if !op.IsRunning() {
+		return nil
+	}
+	op = op.DeepCopy()
+	op.LastTransitionTime = metav1.Now()
+	return r.saveOperation(instance, op)
+}
+
+//status.conditions里记录的是否暂停，用来判断暂停和恢复的时刻
+func isPausedInStatus(instance *crdv1alpha1.RedisCluster) bool {
+	for _, cond := range instance.Status.Conditions {
+		if cond.Type == crdv1alpha1.RedisClusterConditionPaused {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+//status的更新会触发RedisCluster的watch事件，下一步在新的Reconcile里继续
+//不直接Requeue，避免从cache里读到旧的status
+func (r *ReconcileRedisCluster) setOperationPhase(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation, phase crdv1alpha1.OperationPhase, message string) (reconcile.Result, error) {
+	op.Phase = phase
+	op.Message = message
+	op.LastTransitionTime = metav1.Now()
+	if phase == crdv1alpha1.OperationDone || phase == crdv1alpha1.OperationFailed {
+		op.CompletionTime = op.LastTransitionTime.DeepCopy()
+	}
+	if err := r.saveOperation(instance, op); err != nil {
+		return reconcile.Result{}, err
+	}
+	if phase == crdv1alpha1.OperationDone || phase == crdv1alpha1.OperationFailed {
+		recordOperation(instance, op)
+	}
+	switch phase {
+	case crdv1alpha1.OperationDone:
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, operationEvents[op.Type].finished,
+			"Finished %s from %d to %d pods", op.Type, op.FromSize, op.ToSize)
+	case crdv1alpha1.OperationFailed:
+		r.recorder.Event(instance, corev1.EventTypeWarning, operationEvents[op.Type].failed, message)
+	}
+	return reconcile.Result{}, nil
+}
+
+//把操作的进度写入status，operator重启之后从这里继续
+//status.history里同一个操作的记录一起更新，操作执行的计划从status.plan里清除
+func (r *ReconcileRedisCluster) saveOperation(instance *crdv1alpha1.RedisCluster, op *crdv1alpha1.RedisClusterOperation) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1alpha1.RedisCluster{}
+		err := r.client.Get(context.TODO(),
+			types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, latest)
+		if err != nil {
+			return err
+		}
+		latest.Status.Operation = op
+		latest.Status.History = appendHistory(latest.Status.History, op)
+		//开始执行之后计划已经记录在操作里
+		if latest.Status.Plan != nil && latest.Status.Plan.Name == op.Plan {
+			latest.Status.Plan = nil
+		}
+		if err := r.client.Status().Update(context.TODO(), latest); err != nil {
+			return err
+		}
+		instance.Status.Operation = op
+		instance.Status.History = latest.Status.History
+		instance.Status.Plan = latest.Status.Plan
+		return nil
+	})
+}
+
+//job和开始时间相同的是同一个操作，更新原来的记录，否则追加一条，超过maxOperationHistory时丢掉最早的
+func appendHistory(history []crdv1alpha1.RedisClusterOperation,
+	op *crdv1alpha1.RedisClusterOperation) []crdv1alpha1.RedisClusterOperation {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].JobName == op.JobName && history[i].StartTime.Equal(&op.StartTime) {
+			history[i] = *op.DeepCopy()
+			return history
+		}
+	}
+	history = append(history, *op.DeepCopy())
+	if len(history) > maxOperationHistory {
+		history = history[len(history)-maxOperationHistory:]
+	}
+	return history
+}
+
+//扩缩容完成之后，把实际完成的规格记录到status.lastAppliedSpec里
+//操作过程中spec可能又被修改过，这里只记录toSize对应的分片数，剩下的变化交给下一次Reconcile
+func (r *ReconcileRedisCluster) recordAppliedSize(instance *crdv1alpha1.RedisCluster, toSize int32) error {
+	applied := instance.Spec.DeepCopy()
+	shards := toSize / (applied.GetReplicasPerShard() + 1)
+	applied.Shards = &shards
+	applied.Replicas = nil
+	return r.saveAppliedSpec(instance, applied)
+}
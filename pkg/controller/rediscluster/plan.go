@@ -0,0 +1,196 @@
+package rediscluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/utils/rebalance"
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//需要审批时，扩缩容之前先按当前的拓扑计算计划并发布到status.plan
+//crd.xzbc.com.cn/approved-plan的值和计划的名字相同时才返回approved=true，开始执行
+//每次Reconcile都重新计算，集群的拓扑变化之后计划的名字也会变化，批准的是旧的计划时继续等待
+func (r *ReconcileRedisCluster) reconcilePlan(instance *crdv1alpha1.RedisCluster,
+	fromSize, toSize int32) (bool, reconcile.Result, error) {
+	plan, err := r.computePlan(instance, fromSize, toSize)
+	if err != nil {
+		return false, reconcile.Result{}, err
+	}
+	current := instance.Status.Plan
+	if current == nil || current.Name != plan.Name {
+		if err := r.savePlan(instance, plan); err != nil {
+			return false, reconcile.Result{}, err
+		}
+		log.Info("Plan created, waiting for approval", "Request.Namespace", instance.Namespace,
+			"Request.Name", instance.Name, "plan", plan.Name, "from", fromSize, "to", toSize, "slots", plan.Slots)
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, eventPlanCreated,
+			"Plan %s: add %d pods, remove %d pods, move %d slots; approve it by setting the annotation %s=%s",
+			plan.Name, len(plan.AddedNodes), len(plan.RemovedNodes), plan.Slots,
+			crdv1alpha1.ApprovedPlanAnnotation, plan.Name)
+	}
+	if instance.Annotations[crdv1alpha1.ApprovedPlanAnnotation] != plan.Name {
+		return false, reconcile.Result{RequeueAfter: statusResyncPeriod}, nil
+	}
+	r.recorder.Eventf(instance, corev1.EventTypeNormal, eventPlanApproved, "Plan %s approved", plan.Name)
+	return true, reconcile.Result{}, nil
+}
+
+//计算从fromSize扩缩容到toSize时会加入和移出的pod，以及slot的迁移
+//和job、operator执行时的规则相同：新的节点按replicasPerShard+1个一组，每组的第一个是master
+//slot按rebalance计划平均分配给序号小于toSize的master
+func (r *ReconcileRedisCluster) computePlan(instance *crdv1alpha1.RedisCluster,
+	fromSize, toSize int32) (*crdv1alpha1.RedisClusterPlan, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+	if err != nil {
+		return nil, err
+	}
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		return nil, err
+	}
+	_, t, err := readTopology(instance, pods.Items, options)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the cluster topology: %v", err)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no redis pod of %s is reachable", instance.Name)
+	}
+	podByIP := map[string]string{}
+	for _, pod := range pods.Items {
+		if len(pod.Status.PodIP) > 0 {
+			podByIP[pod.Status.PodIP] = pod.Name
+		}
+	}
+	//计划里的节点用pod的名字表示，找不到pod的节点用ip
+	name := func(n *topology.Node) string {
+		if podName, ok := podByIP[n.IP]; ok {
+			return podName
+		}
+		return n.IP
+	}
+	podName := func(ordinal int32) string {
+		return fmt.Sprintf("%s-%d", instance.Name, ordinal)
+	}
+
+	plan := &crdv1alpha1.RedisClusterPlan{
+		FromSize:   fromSize,
+		ToSize:     toSize,
+		Generation: instance.Generation,
+	}
+	var shards []rebalance.Shard
+	for _, master := range t.Masters() {
+		ordinal := int32(-1)
+		if podName, ok := podByIP[master.IP]; ok {
+			ordinal = int32(podOrdinal(podName))
+		}
+		shard := rebalance.Shard{Name: name(master), Slots: master.Slots}
+		if ordinal >= 0 && ordinal < toSize {
+			shard.Weight = 1
+		}
+		if shard.Weight == 0 && len(shard.Slots) == 0 {
+			continue
+		}
+		shards = append(shards, shard)
+	}
+
+	groupSize := instance.Spec.GetReplicasPerShard() + 1
+	for i := fromSize; i < toSize; i++ {
+		node := crdv1alpha1.RedisClusterPlanNode{PodName: podName(i), Role: crdv1alpha1.RoleMaster}
+		if (i-fromSize)%groupSize != 0 {
+			node.Role = crdv1alpha1.RoleSlave
+			node.MasterPodName = podName(i - (i-fromSize)%groupSize)
+		} else {
+			shards = append(shards, rebalance.Shard{Name: node.PodName, Weight: 1})
+		}
+		plan.AddedNodes = append(plan.AddedNodes, node)
+	}
+	for i := fromSize - 1; i >= toSize; i-- {
+		node := crdv1alpha1.RedisClusterPlanNode{PodName: podName(i)}
+		for _, pod := range pods.Items {
+			if pod.Name != node.PodName {
+				continue
+			}
+			if n := t.NodeByIP(pod.Status.PodIP); n != nil {
+				if n.IsMaster() {
+					node.Role = crdv1alpha1.RoleMaster
+				} else if master := t.MasterOf(n); master != nil {
+					node.Role = crdv1alpha1.RoleSlave
+					node.MasterPodName = name(master)
+				}
+			}
+		}
+		plan.RemovedNodes = append(plan.RemovedNodes, node)
+	}
+
+	moves, err := rebalance.Compute(shards)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range moves.Moves {
+		move := crdv1alpha1.RedisClusterSlotMove{Source: m.Source, Target: m.Target, Count: int32(m.SlotCount())}
+		for _, slotRange := range m.Slots {
+			move.Slots = append(move.Slots, slotRange.String())
+		}
+		plan.Moves = append(plan.Moves, move)
+	}
+	plan.Slots = int32(moves.SlotCount())
+
+	//名字由计划的内容决定，内容不变时名字不变
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New32a()
+	h.Write(data)
+	plan.Name = fmt.Sprintf("%d-%d-g%d-%08x", fromSize, toSize, instance.Generation, h.Sum32())
+	plan.CreationTime = metav1.Now()
+	return plan, nil
+}
+
+//把计划写入status.plan，plan为nil时清除
+func (r *ReconcileRedisCluster) savePlan(instance *crdv1alpha1.RedisCluster, plan *crdv1alpha1.RedisClusterPlan) error {
+	if plan == nil && instance.Status.Plan == nil {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1alpha1.RedisCluster{}
+		err := r.client.Get(context.TODO(),
+			types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, latest)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(latest.Status.Plan, plan) {
+			instance.Status.Plan = plan
+			return nil
+		}
+		latest.Status.Plan = plan
+		if err := r.client.Status().Update(context.TODO(), latest); err != nil {
+			return err
+		}
+		instance.Status.Plan = plan
+		return nil
+	})
+}
+
+//开始操作时记录执行的是哪一个计划，只有和批准的名字相同的status.plan才算
+func approvedPlan(instance *crdv1alpha1.RedisCluster) string {
+	plan := instance.Status.Plan
+	if plan == nil || instance.Annotations[crdv1alpha1.ApprovedPlanAnnotation] != plan.Name {
+		return ""
+	}
+	return plan.Name
+}
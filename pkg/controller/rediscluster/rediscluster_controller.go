@@ -2,26 +2,23 @@ package rediscluster
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"math/rand"
 	"reflect"
-	"strconv"
-	"strings"
 	"time"
 	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
 	"xzbc-redis-cluster/pkg/resources/configmap"
 	"xzbc-redis-cluster/pkg/resources/job"
 	"xzbc-redis-cluster/pkg/resources/service"
-	"xzbc-redis-cluster/pkg/resources/statefulset"
+	"xzbc-redis-cluster/pkg/validation"
 
-	"github.com/ericchiang/k8s"
-	simplecorev1 "github.com/ericchiang/k8s/apis/core/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -34,7 +31,10 @@ import (
 )
 
 var log = logf.Log.WithName("controller_rediscluster")
-var isScaleDownFinished bool
+
+//同时处理的RedisCluster个数，同一个RedisCluster不会被两个worker同时处理
+//每个集群的操作进度都记录在自己的status.operation里，worker之间没有共享的状态
+var maxConcurrentReconciles = 4
 //var redisClusterInfo = sync.Map{}
 
 /**
@@ -42,6 +42,14 @@ var isScaleDownFinished bool
 * business logic.  Delete these comments after modifying this file.*
  */
 
+// FlagSet returns the flags used to configure the RedisCluster controller
+func FlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("rediscluster-controller", pflag.ExitOnError)
+	flagSet.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", maxConcurrentReconciles,
+		"Number of RedisClusters reconciled in parallel")
+	return flagSet
+}
+
 // Add creates a new RedisCluster Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
@@ -50,13 +58,20 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileRedisCluster{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileRedisCluster{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetEventRecorderFor("rediscluster-controller"),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
-	c, err := controller.New("rediscluster-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("rediscluster-controller", mgr, controller.Options{
+		Reconciler:              r,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+	})
 	if err != nil {
 		return err
 	}
@@ -67,16 +82,41 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// Watch for changes to secondary resource Pods and requeue the owner RedisCluster
-	/*
-	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForOwner{
+	//statefulset被手工修改或者副本数、就绪数变化时重新处理
+	err = c.Watch(&source.Kind{Type: &appsv1.StatefulSet{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &crdv1alpha1.RedisCluster{},
 	})
 	if err != nil {
 		return err
 	}
-	 */
+
+	//configMap和service被手工修改或者删除之后及时恢复
+	for _, owned := range []runtime.Object{&corev1.ConfigMap{}, &corev1.Service{}} {
+		err = c.Watch(&source.Kind{Type: owned}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &crdv1alpha1.RedisCluster{},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	//pod重启、ip变化、crash loop都需要及时更新集群的状态
+	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &podMapper{},
+	}, podChanged)
+	if err != nil {
+		return err
+	}
+
+	//pvc被删除或者扩容时重新处理
+	err = c.Watch(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &pvcMapper{},
+	})
+	if err != nil {
+		return err
+	}
 
 	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -86,6 +126,14 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	//spec.auth和spec.tls引用的secret发生变化时，重新处理引用它的RedisCluster
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &authSecretMapper{client: mgr.GetClient()},
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -98,6 +146,10 @@ type ReconcileRedisCluster struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	//在RedisCluster上记录event
+	recorder record.EventRecorder
+	//operator执行创建和扩缩容时连接redis节点，为nil时使用clusteradmin.Admin
+	newClusterAdmin func(instance *crdv1alpha1.RedisCluster) (clusterAdmin, error)
 }
 
 // Reconcile reads that state of the cluster for a RedisCluster object and makes changes based on the state read
@@ -114,6 +166,11 @@ func (r *ReconcileRedisCluster) Reconcile(request reconcile.Request) (reconcile.
 	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 	reqLogger.Info("Reconciling RedisCluster")
 
+	start := time.Now()
+	defer func() {
+		reconcileDuration.WithLabelValues(request.Namespace, request.Name).Observe(time.Since(start).Seconds())
+	}()
+
 	// Fetch the RedisCluster instance
 	instance := &crdv1alpha1.RedisCluster{}
 	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
@@ -129,45 +186,111 @@ func (r *ReconcileRedisCluster) Reconcile(request reconcile.Request) (reconcile.
 	}
 
 	//判断instance的DeletionTimestamp是否有值，
-	// 如果有值，说明要被删除了，就直接返回，走k8s的垃圾回收机制
+	// 如果有值，说明要被删除了，取消job并按deletionPolicy处理PVC之后去掉finalizer，其余资源走k8s的垃圾回收机制
+	//删除不受暂停的影响，否则暂停的集群会一直停在Terminating
 	if instance.DeletionTimestamp != nil {
-		return reconcile.Result{}, nil
+		if !hasFinalizer(instance) {
+			return reconcile.Result{}, nil
+		}
+		return r.teardown(instance)
 	}
 
-	//如果查到了，并且不是被删除，就判断它所关联的资源是否存在
-	found := &appsv1.StatefulSet{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, found)
-	if err != nil && errors.IsNotFound(err) {
-
-		//创建redis配置文件需要用到的configMap
-		cm := configmap.New(instance)
-		err = r.client.Create(context.TODO(), cm)
-		if err != nil {
+	//暂停期间不做任何修改，只采集集群的状态
+	if instance.IsPaused() {
+		if !isPausedInStatus(instance) {
+			reqLogger.Info("RedisCluster paused")
+			r.recorder.Event(instance, corev1.EventTypeNormal, eventPaused, "Reconciliation paused, only status is updated")
+		}
+		if statusErr := r.updateStatus(instance); statusErr != nil {
+			reqLogger.Error(statusErr, "Failed to update RedisCluster status")
+		}
+		return reconcile.Result{RequeueAfter: statusResyncPeriod}, nil
+	}
+	if isPausedInStatus(instance) {
+		if err := r.resume(instance); err != nil {
 			return reconcile.Result{}, err
 		}
+	}
 
-		//创建headless service
-		headlessSvc := service.New(instance)
-		err = r.client.Create(context.TODO(), headlessSvc)
-		if err != nil {
+	if !hasFinalizer(instance) {
+		if err := r.addFinalizer(instance); err != nil {
 			return reconcile.Result{}, err
 		}
+	}
 
-		//创建集群对外服务的svc
-		clusterSvc := service.NewClusterSvc(instance)
-		err = r.client.Create(context.TODO(), clusterSvc)
-		if err != nil {
+	result, err := r.reconcileRedisCluster(instance)
+	if err != nil {
+		reconcileErrors.WithLabelValues(request.Namespace, request.Name).Inc()
+	}
+
+	//不管这次处理是否成功，都重新采集一次集群的状态写回status
+	if statusErr := r.updateStatus(instance); statusErr != nil {
+		reqLogger.Error(statusErr, "Failed to update RedisCluster status")
+	}
+
+	//redis集群内部的变化不会触发事件，定期重新入队刷新status
+	if err == nil && !result.Requeue && result.RequeueAfter == 0 {
+		result.RequeueAfter = statusResyncPeriod
+	}
+	return result, err
+}
+
+func (r *ReconcileRedisCluster) reconcileRedisCluster(instance *crdv1alpha1.RedisCluster) (reconcile.Result, error) {
+	//老版本operator写入的clustermode: false没有意义，先改回true，避免把已有的redis cluster当成主从模式处理
+	if instance.HasStaleClusterMode() {
+		clusterMode := true
+		instance.Spec.ClusterMode = &clusterMode
+		if err := r.client.Update(context.TODO(), instance); err != nil {
 			return reconcile.Result{}, err
 		}
+		return reconcile.Result{Requeue: true}, nil
+	}
+
+	//webhook没有部署的时候，不合法的CR也会进到这里，直接返回，避免operator panic
+	if allErrs := validation.ValidateRedisCluster(instance); len(allErrs) > 0 {
+		log.Error(allErrs.ToAggregate(), "Invalid RedisCluster spec, skipping",
+			"Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+		r.recorder.Event(instance, corev1.EventTypeWarning, eventInvalidSpec, allErrs.ToAggregate().Error())
+		return reconcile.Result{}, nil
+	}
+
+	//只设置了replicas的老的CR，先换算成shards和replicasPerShard再处理
+	if instance.Spec.IsLegacySizing() {
+		return r.migrateLegacySizing(instance)
+	}
+
+	//clustermode为false时部署master/slave和sentinel
+	if !instance.Spec.IsClusterMode() {
+		return r.reconcileReplication(instance)
+	}
 
-		//创建做redis-trib的job
-		redisTribJob := job.New(instance)
-		if err := controllerutil.SetControllerReference(instance, redisTribJob, r.scheme); err != nil {
+	//configMap和service每次都按spec检查一遍，被手工修改或者删除之后会恢复
+	//spec.config变化之后configMap也在这里更新，statefulset更新之后pod会读到新的配置
+	if err := r.ensureConfigMap(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	for _, svc := range []*corev1.Service{service.New(instance), service.NewClusterSvc(instance)} {
+		if err := r.ensureService(instance, svc); err != nil {
 			return reconcile.Result{}, err
 		}
-		err = r.client.Create(context.TODO(), redisTribJob)
-		if err != nil {
-			return reconcile.Result{}, err
+	}
+
+	//如果查到了，并且不是被删除，就判断它所关联的资源是否存在
+	found := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+
+		//创建做redis-trib的job，由operator执行时不创建job，等pod就绪之后operator直接连接节点创建集群
+		var redisTribJob *batchv1.Job
+		if instance.Spec.GetExecutor() == crdv1alpha1.ExecutorJob {
+			redisTribJob = job.New(instance, job.Name(instance, job.TypeCreate, 0, instance.Spec.GetClusterSize(), instance.Generation))
+			if err := controllerutil.SetControllerReference(instance, redisTribJob, r.scheme); err != nil {
+				return reconcile.Result{}, err
+			}
+			err = r.createOperationJob(instance, redisTribJob)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
 		}
 
 		/*
@@ -183,186 +306,179 @@ func (r *ReconcileRedisCluster) Reconcile(request reconcile.Request) (reconcile.
 		}
 		 */
 
-		sts := statefulset.New(instance)
+		sts, err := r.desiredStatefulSet(instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
 		err = r.client.Create(context.TODO(), sts)
 		if err != nil {
-			//如果创建sts报错，把之前创建的关联资源删除后再返回错误
-			go r.client.Delete(context.TODO(), headlessSvc)
-			go r.client.Delete(context.TODO(), cm)
-			go r.client.Delete(context.TODO(), clusterSvc)
-			go r.client.Delete(context.TODO(), redisTribJob)
+			//如果创建sts报错，把job删除后再返回错误，下一次Reconcile重新创建
+			if redisTribJob != nil {
+				go r.client.Delete(context.TODO(), redisTribJob)
+			}
 			return reconcile.Result{}, err
 		}
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, eventCreated, "Created StatefulSet %s", sts.Name)
 
-		//创建完成之后还得去做一次更新
-		//把对应的annotation给更新上，因为后面需要用annotation去做判断是否需要去做更新操作
-		instance.Annotations = map[string]string{
-			"crd.xzbc.com.cn/spec":toString(instance),
+		//pod启动时已经从secret中读取了密码，记录下当前生效的密码
+		if err := r.reconcileAuth(instance, false); err != nil {
+			return reconcile.Result{}, err
 		}
 
-		//redisClusterInfo.Store("redisClusterCurrentSpec",instance.Spec)
-
-		//做更新操作，使用retry来防止panic
-		retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-			return r.client.Update(context.TODO(), instance)
-		})
-		if retryErr != nil {
-			fmt.Println(retryErr.Error())
+		//记录创建时的spec，后面用它判断是否需要去做更新操作
+		if err := r.saveAppliedSpec(instance, instance.Spec.DeepCopy()); err != nil {
+			r.recorder.Eventf(instance, corev1.EventTypeWarning, eventUpdateFailed,
+				"Failed to record the applied spec: %v", err)
 		}
 
-		return reconcile.Result{}, nil
+		//记录create job的进度，集群创建完成之前不做扩缩容
+		if redisTribJob == nil {
+			return r.startOperatorOperation(instance, crdv1alpha1.OperationCreate, 0, instance.Spec.GetClusterSize())
+		}
+		return r.startOperation(instance, crdv1alpha1.OperationCreate, 0, instance.Spec.GetClusterSize(), redisTribJob.Name, 0)
 
 	} else if err != nil {
 		return reconcile.Result{}, err
 	}
 
-	//instance.Annotations["crd.xzbc.com.cn/spec"]这是老的信息
+	//auth secret中的密码发生了变化，修改所有节点上的密码
+	if err := r.reconcileAuth(instance, true); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	//老版本operator记录在annotation里的spec迁移到status里
+	if err := r.migrateLegacyAnnotation(instance, found); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	//创建集群或者扩缩容还没有结束，先把它推进完，再处理spec的其他变化
+	if instance.Status.Operation.IsRunning() {
+		return r.reconcileOperation(instance, found)
+	}
+
+	//TLS secret中的证书发生了变化，滚动重启pod加载新证书
+	//和spec的变化一样等操作结束之后再做，创建集群、迁移slot的时候重启pod会让操作失败
+	if err := r.reconcileTLS(instance, found); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	//status.lastAppliedSpec这是老的信息
 	//instance.spec是期望的最新的信息，使用DeepEqual方法比较是否相等
-	if ! reflect.DeepEqual(instance.Spec,toSpec(instance.Annotations["crd.xzbc.com.cn/spec"])) {
+	oldSpec := appliedSpec(instance, found)
+	if ! reflect.DeepEqual(instance.Spec, *oldSpec) {
 		//如果不相等，就需要去更新，更新就是重建sts和svc
 		//但是更新操作通常是不会去更新svc的，只需要更新sts
-		oldClusterSize := fmt.Sprintf("%v",*(toSpec(instance.Annotations["crd.xzbc.com.cn/spec"]).Replicas))
-		newClusterSize := fmt.Sprintf("%v",*(instance.Spec.Replicas))
+		oldClusterSizeInt := int(oldSpec.GetClusterSize())
+		newClusterSizeInt := int(instance.Spec.GetClusterSize())
+
+		//上一次扩缩容失败之后不自动重试，用户修改spec之后generation变化才重新开始
+		if newClusterSizeInt != oldClusterSizeInt && instance.Status.Operation.IsFailedAt(instance.Generation) {
+			log.Info("Last operation failed, waiting for the spec to change",
+				"Request.Namespace", instance.Namespace, "Request.Name", instance.Name,
+				"message", instance.Status.Operation.Message)
+			return reconcile.Result{}, nil
+		}
 
-		oldClusterSizeInt,_ := strconv.Atoi(oldClusterSize)
-		newClusterSizeInt,_ := strconv.Atoi(newClusterSize)
+		//开启审批时先发布扩缩容的计划，计划被批准之后才开始执行
+		if newClusterSizeInt != oldClusterSizeInt && instance.RequiresApproval() {
+			approved, result, err := r.reconcilePlan(instance, int32(oldClusterSizeInt), int32(newClusterSizeInt))
+			if !approved {
+				return result, err
+			}
+		}
 
 		if newClusterSizeInt  > oldClusterSizeInt {
 			//要做扩容操作
-			sts := statefulset.New(instance)
-			found.Spec = sts.Spec
-
-			//创建scale job
-			jobName := RandString(8) //创建一个job的name
-			newScaleJob := job.NewScaleJob(instance,oldClusterSize,newClusterSize,jobName)
-			err = r.client.Create(context.TODO(), newScaleJob)
-			if err != nil {
-				return reconcile.Result{}, err
-			}
-
-			//更新sts
-			//更新要用retry操作去做
-			retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				return r.client.Update(context.TODO(), found)
-			})
-			if retryErr != nil {
-				go r.client.Delete(context.TODO(), newScaleJob)
-				return reconcile.Result{}, err //如果retry报错，就返回给下一次处理
-			}
-
-			//如果更新成功,把最新的spec信息更新进annotation
-			instance.Annotations = map[string]string{
-				"crd.xzbc.com.cn/spec":toString(instance),
-			}
-			retryErr = retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				return r.client.Update(context.TODO(), instance)
-			})
-			if retryErr != nil {
-				fmt.Println(retryErr.Error())
-			}
+			return r.startScaleUp(instance, found, int32(oldClusterSizeInt), int32(newClusterSizeInt))
 
 		} else if newClusterSizeInt <  oldClusterSizeInt {
 			//要做缩容操作
 			//先调用job，把需要删除的pod副本上的slot全部转移到其他节点上之后再执行sts的更新操作
-
-			if !isScaleDownFinished { //如果缩容没有完成，不进入缩容的逻辑
-
-				//创建一个job的label,后面需要用这个这个label去判断job是否运行成功
-				jobName := RandString(8)
-				newDelJob := job.NewScaleJob(instance,oldClusterSize,newClusterSize,jobName)
-				err = r.client.Create(context.TODO(), newDelJob)
-				if err != nil {
-					return reconcile.Result{}, err
-				}
-
-				simpleClient, err := k8s.NewInClusterClient()
-				if err != nil {
-					fmt.Println(err)
-				}
-
-			EXIT:
-				for {
-					var pods simplecorev1.PodList
-					if err := simpleClient.List(context.Background(), instance.Namespace, &pods); err != nil {
-						fmt.Println(err)
-					}
-
-					for _,item := range pods.Items {
-						if strings.Index(*item.Metadata.Name,jobName) != -1 && *item.Status.Phase == "Succeeded" {
-							isScaleDownFinished = true
-							break EXIT
-						}
-					}
-					time.Sleep(time.Second * 5)
-				}
-			}
-
-
-			//job操作完成之后，开始做sts的逻辑，把多余的副本杀掉
-			sts := statefulset.New(instance)
-			found.Spec = sts.Spec
-
-			//然后就去更新
-			retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				return r.client.Update(context.TODO(), found)
-			})
-			if retryErr != nil {
-				return reconcile.Result{}, err //如果retry报错，就返回给下一次处理
-			}
-
-			//如果更新成功,把最新的spec信息更新进annotation
-			instance.Annotations = map[string]string{
-				"crd.xzbc.com.cn/spec":toString(instance),
-			}
-			retryErr = retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				return r.client.Update(context.TODO(), instance)
-			})
-			if retryErr != nil {
-				fmt.Println(retryErr.Error())
-			}
-
-			//check := &appsv1.StatefulSet{}
-			//err = r.client.Get(context.TODO(),
-			//	types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, check)
-
+			//缩容是一个状态机，每次Reconcile推进一步，不会阻塞worker
+			return r.startScaleDown(instance, int32(oldClusterSizeInt), int32(newClusterSizeInt))
 
 		} else {
 			//不变更集群规模，做statefulset的更新操作
-			sts := statefulset.New(instance)
-			found.Spec = sts.Spec
+			sts, err := r.desiredStatefulSet(instance)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			mergeStatefulSetSpec(found, sts)
 
 			//然后就去更新，更新要用retry操作去做
 			retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 				return r.client.Update(context.TODO(), found)
 			})
 			if retryErr != nil {
-				return reconcile.Result{}, err //如果retry报错，就返回给下一次处理
+				r.recorder.Eventf(instance, corev1.EventTypeWarning, eventUpdateFailed,
+					"Failed to update StatefulSet %s: %v", found.Name, retryErr)
+				return reconcile.Result{}, retryErr //如果retry报错，就返回给下一次处理
 			}
 		}
 
+	} else if err := r.repairStatefulSet(instance); err != nil {
+		//spec没有变化时statefulset也要和spec一致，手工修改的statefulset在这里恢复
+		return reconcile.Result{}, err
+	}
+	//集群规模没有变化，之前发布的计划已经没有意义
+	if err := r.savePlan(instance, nil); err != nil {
+		return reconcile.Result{}, err
+	}
+	//spec没有变化时也要记录一次，暂停期间修改又改回来的spec只更新observedGeneration
+	if err := r.saveAppliedSpec(instance, instance.Spec.DeepCopy()); err != nil {
+		return reconcile.Result{}, err
 	}
 	return reconcile.Result{}, nil
 }
 
 
-func toString(redisCluster *crdv1alpha1.RedisCluster) string {
-	bytes, _ := json.Marshal(redisCluster.Spec)
-	return  string(bytes)
+//把只设置了replicas的老的CR换算成shards和replicasPerShard
+//已经创建过的集群，status.lastAppliedSpec在记录的时候已经是换算之后的
+func (r *ReconcileRedisCluster) migrateLegacySizing(instance *crdv1alpha1.RedisCluster) (reconcile.Result, error) {
+	if err := migrateSpecSizing(&instance.Spec); err != nil {
+		//不能整除的时候无法确定分片的布局，不做任何操作，等用户修正CR之后再处理
+		log.Error(err, "Cannot migrate legacy replicas to shards",
+			"Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+		r.recorder.Event(instance, corev1.EventTypeWarning, eventInvalidSpec, err.Error())
+		return reconcile.Result{}, nil
+	}
+
+	err := r.client.Update(context.TODO(), instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{Requeue: true}, nil
 }
 
-func toSpec(data string) crdv1alpha1.RedisClusterSpec {
-	redisClusterSpec := crdv1alpha1.RedisClusterSpec{}
-	_ = json.Unmarshal([]byte(data), &redisClusterSpec)
-	return redisClusterSpec
+//replicas必须是replicasPerShard+1的整数倍，否则会出现没有slave的master
+func migrateSpecSizing(spec *crdv1alpha1.RedisClusterSpec) error {
+	groupSize := spec.GetReplicasPerShard() + 1
+	if *spec.Replicas%groupSize != 0 {
+		return fmt.Errorf("spec.replicas %d is not a multiple of replicasPerShard+1 (%d)",
+			*spec.Replicas, groupSize)
+	}
+	shards := *spec.Replicas / groupSize
+	replicasPerShard := groupSize - 1
+	spec.Shards = &shards
+	spec.ReplicasPerShard = &replicasPerShard
+	spec.Replicas = nil
+	spec.ReplicasPerMaster = nil
+	return nil
 }
 
-//根据输入长度生成一个随机字符串，k8s的命名规范要求全小写的域名
-func RandString(len int) string {
-	r := rand.New(rand.NewSource(time.Now().Unix()))
-	bytes := make([]byte, len)
-	for i := 0; i < len; i++ {
-		b := r.Intn(26) + 65
-		bytes[i] = byte(b)
+//集群模式和主从模式的configMap内容不同
+func desiredConfigMap(instance *crdv1alpha1.RedisCluster) *corev1.ConfigMap {
+	if instance.Spec.IsClusterMode() {
+		return configmap.New(instance)
 	}
-	return strings.ToLower(string(bytes))
-}
\ No newline at end of file
+	return configmap.NewReplication(instance)
+}
+
+//volumeClaimTemplates和selector创建之后不能修改，更新statefulset时保留原来的值
+func mergeStatefulSetSpec(found, desired *appsv1.StatefulSet) {
+	volumeClaimTemplates := found.Spec.VolumeClaimTemplates
+	selector := found.Spec.Selector
+	found.Spec = desired.Spec
+	found.Spec.VolumeClaimTemplates = volumeClaimTemplates
+	found.Spec.Selector = selector
+}
@@ -0,0 +1,208 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/service"
+	"xzbc-redis-cluster/pkg/resources/statefulset"
+	"xzbc-redis-cluster/pkg/resources/utils/redisclient"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//clustermode为false时的处理逻辑：一个master加若干slave，由sentinel做故障转移
+//这种模式不需要job，所有资源都按spec直接创建或更新
+func (r *ReconcileRedisCluster) reconcileReplication(instance *crdv1alpha1.RedisCluster) (reconcile.Result, error) {
+	found := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	created := err == nil
+
+	if err := r.ensureConfigMap(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	for _, svc := range []*corev1.Service{
+		service.New(instance),
+		service.NewMasterSvc(instance),
+		service.NewSentinelSvc(instance),
+	} {
+		if err := r.ensureService(instance, svc); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err := r.ensureStatefulSet(instance, statefulset.NewReplication(instance)); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.ensureStatefulSet(instance, statefulset.NewSentinel(instance)); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileAuth(instance, created); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	//sentinel完成故障转移之后，把role label切换到新的master上
+	if err := r.reconcileRoles(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.saveAppliedSpec(instance, instance.Spec.DeepCopy()); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+//statefulset不存在就创建，pod模板或者副本数和期望的不一致时更新
+//apiserver会给statefulset填充默认值，用DeepDerivative只比较期望的spec里设置了的字段
+func (r *ReconcileRedisCluster) ensureStatefulSet(instance *crdv1alpha1.RedisCluster, sts *appsv1.StatefulSet) error {
+	found := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: sts.Name, Namespace: sts.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.createObject(instance, sts, "StatefulSet")
+	} else if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepDerivative(sts.Spec.Template, found.Spec.Template) &&
+		found.Spec.Replicas != nil && *found.Spec.Replicas == *sts.Spec.Replicas {
+		return nil
+	}
+	mergeStatefulSetSpec(found, sts)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.client.Update(context.TODO(), found)
+	})
+}
+
+//找到当前的master，更新所有redis pod上的role label
+func (r *ReconcileRedisCluster) reconcileRoles(instance *crdv1alpha1.RedisCluster) error {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+	if err != nil {
+		return err
+	}
+
+	masterIP, err := r.currentMaster(instance, pods.Items)
+	if err != nil {
+		//还没有选出master的时候不修改label，service继续指向原来的pod
+		log.Info("Cannot determine the current master", "Request.Namespace", instance.Namespace,
+			"Request.Name", instance.Name, "error", err.Error())
+		return nil
+	}
+
+	var oldMaster, newMaster string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Labels[crdv1alpha1.RoleLabel] == crdv1alpha1.RoleMaster {
+			oldMaster = pod.Name
+		}
+		if pod.Status.PodIP == masterIP {
+			newMaster = pod.Name
+		}
+	}
+	if len(oldMaster) > 0 && len(newMaster) > 0 && oldMaster != newMaster {
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, eventFailover,
+			"Sentinel promoted %s to master, previous master was %s", newMaster, oldMaster)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+			continue
+		}
+		role := crdv1alpha1.RoleSlave
+		if pod.Status.PodIP == masterIP {
+			role = crdv1alpha1.RoleMaster
+		}
+		if pod.Labels[crdv1alpha1.RoleLabel] == role {
+			continue
+		}
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[crdv1alpha1.RoleLabel] = role
+		if err := r.client.Update(context.TODO(), pod); err != nil {
+			return err
+		}
+		log.Info("Updated redis role", "Pod", pod.Name, "role", role)
+	}
+	return nil
+}
+
+//优先使用sentinel的结果，sentinel都不可用时使用redis节点自己上报的角色
+func (r *ReconcileRedisCluster) currentMaster(instance *crdv1alpha1.RedisCluster, pods []corev1.Pod) (string, error) {
+	sentinels := &corev1.PodList{}
+	err := r.client.List(context.TODO(), sentinels, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{crdv1alpha1.SentinelLabel: instance.Name})
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range sentinels.Items {
+		if len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if masterIP, err := sentinelMaster(pod.Status.PodIP, instance.Name); err == nil {
+			return masterIP, nil
+		}
+	}
+
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		return "", err
+	}
+	var masters []string
+	for _, pod := range pods {
+		if len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+			continue
+		}
+		info, err := nodeInfo(nodeAddr(instance, pod.Status.PodIP), options)
+		if err == nil && info["role"] == crdv1alpha1.RoleMaster {
+			masters = append(masters, pod.Status.PodIP)
+		}
+	}
+	if len(masters) != 1 {
+		return "", fmt.Errorf("found %d redis nodes reporting role master", len(masters))
+	}
+	return masters[0], nil
+}
+
+//SENTINEL GET-MASTER-ADDR-BY-NAME返回master的ip和端口
+func sentinelMaster(sentinelIP, masterName string) (string, error) {
+	c, err := redisclient.Dial(fmt.Sprintf("%s:%d", sentinelIP, crdv1alpha1.SentinelPort))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	reply, err := c.Do("SENTINEL", "GET-MASTER-ADDR-BY-NAME", masterName)
+	if err != nil {
+		return "", err
+	}
+	addr, ok := reply.([]interface{})
+	if !ok || len(addr) != 2 {
+		return "", fmt.Errorf("sentinel does not monitor %s", masterName)
+	}
+	ip, _ := addr[0].(string)
+	return ip, nil
+}
+
+//INFO的全部内容，包括role、master_host、master_link_status和run_id
+func nodeInfo(addr string, options redisclient.Options) (map[string]string, error) {
+	c, err := redisclient.DialWithOptions(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Info()
+}
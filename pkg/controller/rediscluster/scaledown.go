@@ -0,0 +1,146 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/job"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//减少StatefulSet副本数之后，等待多余pod退出的最长时间
+const scaleDownShrinkTimeout = 10 * time.Minute
+
+//缩容的状态机，每次Reconcile只推进一步，进度记录在status.operation里
+//JobCreated -> SlotsDrained -> StatefulSetShrunk -> Done，任何一步出错或者超时都会进入Failed
+//由operator执行时SlotsDrained之前的阶段见reconcileOperatorStep
+func (r *ReconcileRedisCluster) reconcileScaleDown(instance *crdv1alpha1.RedisCluster, found *appsv1.StatefulSet,
+	op *crdv1alpha1.RedisClusterOperation) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	switch op.Phase {
+	case crdv1alpha1.OperationJobCreated:
+		if finished, result, err := r.waitForJob(instance, op); !finished {
+			return result, err
+		}
+
+		//job成功之后再确认一次，要删除的节点确实已经不在集群里了
+		if err := r.verifySlotsDrained(instance, op.ToSize); err != nil {
+			return r.failOperation(instance, op, err.Error())
+		}
+		reqLogger.Info("Slots drained from the removed nodes", "job", op.JobName)
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationSlotsDrained, "")
+
+	case crdv1alpha1.OperationSlotsDrained:
+		//节点已经移出集群，减少StatefulSet的副本数
+		sts, err := r.desiredStatefulSet(instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		replicas := op.ToSize
+		sts.Spec.Replicas = &replicas
+		mergeStatefulSetSpec(found, sts)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			return r.client.Update(context.TODO(), found)
+		})
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationStatefulSetShrunk, "")
+
+	case crdv1alpha1.OperationStatefulSetShrunk:
+		if found.Status.Replicas != op.ToSize {
+			if time.Since(op.LastTransitionTime.Time) > scaleDownShrinkTimeout {
+				return r.failOperation(instance, op, "timed out waiting for the StatefulSet to remove pods")
+			}
+			return reconcile.Result{RequeueAfter: operationPollInterval}, nil
+		}
+
+		if err := r.recordAppliedSize(instance, op.ToSize); err != nil {
+			return reconcile.Result{}, err
+		}
+		reqLogger.Info("Scale-down finished", "from", op.FromSize, "to", op.ToSize)
+		return r.setOperationPhase(instance, op, crdv1alpha1.OperationDone, "")
+	}
+	return reconcile.Result{}, nil
+}
+
+//创建迁移slot的job，进入JobCreated
+//由operator执行时不创建job，从等待pod就绪开始，迁移完slot、移出节点之后进入SlotsDrained
+func (r *ReconcileRedisCluster) startScaleDown(instance *crdv1alpha1.RedisCluster,
+	fromSize, toSize int32) (reconcile.Result, error) {
+	if instance.Spec.GetExecutor() == crdv1alpha1.ExecutorOperator {
+		return r.startOperatorOperation(instance, crdv1alpha1.OperationScaleDown, fromSize, toSize)
+	}
+	newDelJob, err := r.findRunningJob(instance, job.TypeScale)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if newDelJob == nil {
+		jobName := job.Name(instance, job.TypeScale, fromSize, toSize, instance.Generation)
+		newDelJob = job.NewScaleJob(instance, strconv.Itoa(int(fromSize)), strconv.Itoa(int(toSize)), jobName)
+		err = r.createOperationJob(instance, newDelJob)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	//要删除的pod上的slot都会被迁移走，统计不到时不影响缩容
+	slots, err := r.slotsOnPods(instance, toSize)
+	if err != nil {
+		log.Info("Cannot count the slots to migrate", "Request.Namespace", instance.Namespace,
+			"Request.Name", instance.Name, "error", err.Error())
+	}
+	return r.startOperation(instance, crdv1alpha1.OperationScaleDown, fromSize, toSize, newDelJob.Name, slots)
+}
+
+//确认序号不小于toSize的pod已经不在集群里了，并且所有slot都分配在剩下的节点上
+func (r *ReconcileRedisCluster) verifySlotsDrained(instance *crdv1alpha1.RedisCluster, toSize int32) error {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+	if err != nil {
+		return err
+	}
+
+	//先连接保留下来的节点，被移除的节点重启之后还会认为自己在集群里
+	var remaining, removed []corev1.Pod
+	for _, pod := range pods.Items {
+		if int32(podOrdinal(pod.Name)) >= toSize {
+			removed = append(removed, pod)
+		} else {
+			remaining = append(remaining, pod)
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		return podOrdinal(remaining[i].Name) < podOrdinal(remaining[j].Name)
+	})
+
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		return err
+	}
+	info, nodes, err := fetchTopology(instance, append(remaining, removed...), options)
+	if err != nil {
+		return fmt.Errorf("cannot read the cluster topology: %v", err)
+	}
+	if info["cluster_slots_assigned"] != strconv.Itoa(totalSlots) {
+		return fmt.Errorf("only %s of %d slots are assigned after the job finished",
+			info["cluster_slots_assigned"], totalSlots)
+	}
+	for _, node := range nodes {
+		if int32(podOrdinal(node.PodName)) >= toSize && len(node.NodeID) > 0 {
+			return fmt.Errorf("pod %s is still a cluster member, slots: %s", node.PodName, strings.Join(node.Slots, " "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package rediscluster
+
+import (
+	"context"
+	"strconv"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/job"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//创建scale job并增加StatefulSet的副本数，job等新的pod启动之后把它们加入集群并迁移slot
+//由operator执行时只增加副本数，之后的步骤在reconcileOperatorStep里完成
+func (r *ReconcileRedisCluster) startScaleUp(instance *crdv1alpha1.RedisCluster, found *appsv1.StatefulSet,
+	fromSize, toSize int32) (reconcile.Result, error) {
+	sts, err := r.desiredStatefulSet(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	mergeStatefulSetSpec(found, sts)
+
+	//由operator执行时不需要job，更新sts之后等新的pod就绪
+	var newScaleJob *batchv1.Job
+	if instance.Spec.GetExecutor() == crdv1alpha1.ExecutorJob {
+		newScaleJob, err = r.findRunningJob(instance, job.TypeScale)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if newScaleJob == nil {
+			jobName := job.Name(instance, job.TypeScale, fromSize, toSize, instance.Generation)
+			newScaleJob = job.NewScaleJob(instance, strconv.Itoa(int(fromSize)), strconv.Itoa(int(toSize)), jobName)
+			err = r.createOperationJob(instance, newScaleJob)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	}
+
+	//更新sts，更新要用retry操作去做
+	//更新失败时job会一直等待新的pod，下一次Reconcile沿用这个job并重新更新sts
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.client.Update(context.TODO(), found)
+	})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if newScaleJob == nil {
+		return r.startOperatorOperation(instance, crdv1alpha1.OperationScaleUp, fromSize, toSize)
+	}
+	return r.startOperation(instance, crdv1alpha1.OperationScaleUp, fromSize, toSize, newScaleJob.Name, 0)
+}
+
+//等待scale job把新的节点加入集群，完成之后把扩容之后的规格记录到status.lastAppliedSpec里
+func (r *ReconcileRedisCluster) reconcileScaleUp(instance *crdv1alpha1.RedisCluster,
+	op *crdv1alpha1.RedisClusterOperation) (reconcile.Result, error) {
+	if finished, result, err := r.waitForJob(instance, op); !finished {
+		return result, err
+	}
+	//新加入的pod上的slot都是从原来的节点迁移过来的
+	if slots, err := r.slotsOnPods(instance, op.FromSize); err == nil {
+		op.Slots = slots
+	} else {
+		log.Info("Cannot count the migrated slots", "Request.Namespace", instance.Namespace,
+			"Request.Name", instance.Name, "error", err.Error())
+	}
+	if err := r.recordAppliedSize(instance, op.ToSize); err != nil {
+		return reconcile.Result{}, err
+	}
+	log.Info("Scale-up finished", "Request.Namespace", instance.Namespace, "Request.Name", instance.Name,
+		"from", op.FromSize, "to", op.ToSize)
+	return r.setOperationPhase(instance, op, crdv1alpha1.OperationDone, "")
+}
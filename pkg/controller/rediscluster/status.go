@@ -0,0 +1,463 @@
+package rediscluster
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/job"
+	"xzbc-redis-cluster/pkg/resources/utils/redisclient"
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//集群状态不会通过事件通知operator，需要定期重新采集
+const statusResyncPeriod = 30 * time.Second
+
+const totalSlots = topology.TotalSlots
+
+//从k8s资源和redis节点上采集到的集群当前状态
+type observedState struct {
+	sts          *appsv1.StatefulSet
+	pods         []corev1.Pod
+	jobs         []batchv1.Job
+	clusterInfo  map[string]string
+	nodes        []crdv1alpha1.RedisClusterNode
+	clusterError error
+	//clustermode为false时当前master的ip
+	master string
+}
+
+//重新采集集群状态，计算phase和conditions，写回status
+func (r *ReconcileRedisCluster) updateStatus(instance *crdv1alpha1.RedisCluster) error {
+	observed, err := r.observe(instance)
+	if err != nil {
+		return err
+	}
+
+	var promoted []string
+	var phase crdv1alpha1.RedisClusterPhase
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1alpha1.RedisCluster{}
+		err := r.client.Get(context.TODO(),
+			types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, latest)
+		if err != nil {
+			return err
+		}
+
+		status := latest.Status.DeepCopy()
+		computeStatus(latest, status, observed)
+		phase = status.Phase
+		if reflect.DeepEqual(status, &latest.Status) {
+			return nil
+		}
+		promoted = promotedNodes(latest.Status.Nodes, status.Nodes)
+		latest.Status = *status
+		return r.client.Status().Update(context.TODO(), latest)
+	})
+	if err != nil {
+		return err
+	}
+	recordPhase(instance, phase)
+
+	//主从模式的故障转移在reconcileRoles里记录
+	if instance.Spec.IsClusterMode() {
+		for _, podName := range promoted {
+			r.recorder.Eventf(instance, corev1.EventTypeWarning, eventFailover,
+				"%s was promoted from slave to master", podName)
+		}
+	}
+	return nil
+}
+
+//上一次status里是slave、这一次变成master的节点，同一个pod并且node id没有变化
+func promotedNodes(oldNodes, newNodes []crdv1alpha1.RedisClusterNode) []string {
+	var promoted []string
+	for _, newNode := range newNodes {
+		if newNode.Role != crdv1alpha1.RoleMaster || len(newNode.NodeID) == 0 {
+			continue
+		}
+		for _, oldNode := range oldNodes {
+			if oldNode.PodName == newNode.PodName && oldNode.NodeID == newNode.NodeID &&
+				oldNode.Role == crdv1alpha1.RoleSlave {
+				promoted = append(promoted, newNode.PodName)
+			}
+		}
+	}
+	return promoted
+}
+
+func (r *ReconcileRedisCluster) observe(instance *crdv1alpha1.RedisCluster) (*observedState, error) {
+	observed := &observedState{}
+
+	sts := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, sts)
+	if err == nil {
+		observed.sts = sts
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	pods := &corev1.PodList{}
+	err = r.client.List(context.TODO(), pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn/v1alpha1": instance.Name})
+	if err != nil {
+		return nil, err
+	}
+	observed.pods = pods.Items
+	sort.Slice(observed.pods, func(i, j int) bool {
+		return podOrdinal(observed.pods[i].Name) < podOrdinal(observed.pods[j].Name)
+	})
+
+	jobs := &batchv1.JobList{}
+	err = r.client.List(context.TODO(), jobs, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn": instance.Name})
+	if err != nil {
+		return nil, err
+	}
+	observed.jobs = jobs.Items
+	sort.Slice(observed.jobs, func(i, j int) bool {
+		return observed.jobs[i].CreationTimestamp.Before(&observed.jobs[j].CreationTimestamp)
+	})
+
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		observed.clusterError = err
+		observed.nodes = buildNodes(observed.pods, nil)
+		return observed, nil
+	}
+	if !instance.Spec.IsClusterMode() {
+		observed.clusterInfo, observed.nodes, observed.master = fetchReplication(instance, observed.pods, options)
+		return observed, nil
+	}
+	observed.clusterInfo, observed.nodes, observed.clusterError = fetchTopology(instance, observed.pods, options)
+	return observed, nil
+}
+
+//连接第一个可以连通的pod，通过CLUSTER INFO和CLUSTER NODES获取整个集群的拓扑
+func fetchTopology(instance *crdv1alpha1.RedisCluster, pods []corev1.Pod, options redisclient.Options) (map[string]string, []crdv1alpha1.RedisClusterNode, error) {
+	info, t, err := readTopology(instance, pods, options)
+	if err != nil {
+		return nil, buildNodes(pods, nil), err
+	}
+	return info, buildNodes(pods, t), nil
+}
+
+//没有可以连接的pod时返回的拓扑和错误都是nil
+func readTopology(instance *crdv1alpha1.RedisCluster, pods []corev1.Pod, options redisclient.Options) (map[string]string, *topology.Topology, error) {
+	var lastErr error
+	for _, pod := range pods {
+		if len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+			continue
+		}
+		c, err := redisclient.DialWithOptions(nodeAddr(instance, pod.Status.PodIP), options)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		info, err := c.ClusterInfo()
+		if err != nil {
+			c.Close()
+			lastErr = err
+			continue
+		}
+		nodesStr, err := c.ClusterNodes()
+		c.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		t, err := topology.Parse(nodesStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return info, t, nil
+	}
+	return nil, nil, lastErr
+}
+
+//把CLUSTER NODES解析出来的拓扑和pod对应起来，没有加入集群的pod也会出现在结果里
+//正在迁移的slot不记录，t为nil时只返回pod
+func buildNodes(pods []corev1.Pod, t *topology.Topology) []crdv1alpha1.RedisClusterNode {
+	byIP := map[string]crdv1alpha1.RedisClusterNode{}
+	if t != nil {
+		for _, n := range t.Nodes {
+			node := crdv1alpha1.RedisClusterNode{IP: n.IP, NodeID: n.ID, MasterID: n.MasterID}
+			switch {
+			case n.IsMaster():
+				node.Role = crdv1alpha1.RoleMaster
+			case n.IsReplica():
+				node.Role = crdv1alpha1.RoleSlave
+			}
+			for _, slotRange := range n.Slots {
+				node.Slots = append(node.Slots, slotRange.String())
+			}
+			byIP[n.IP] = node
+		}
+	}
+
+	var nodes []crdv1alpha1.RedisClusterNode
+	for _, pod := range pods {
+		node, ok := byIP[pod.Status.PodIP]
+		if !ok || len(pod.Status.PodIP) == 0 {
+			node = crdv1alpha1.RedisClusterNode{IP: pod.Status.PodIP}
+		}
+		node.PodName = pod.Name
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+//clustermode为false时，通过每个节点的INFO获取主从关系
+//只有一个master，并且其他节点都已经连上这个master的时候cluster_state才是ok
+func fetchReplication(instance *crdv1alpha1.RedisCluster, pods []corev1.Pod,
+	options redisclient.Options) (map[string]string, []crdv1alpha1.RedisClusterNode, string) {
+	var nodes []crdv1alpha1.RedisClusterNode
+	var masters []string
+	infos := map[string]map[string]string{}
+	for _, pod := range pods {
+		node := crdv1alpha1.RedisClusterNode{PodName: pod.Name, IP: pod.Status.PodIP}
+		if len(pod.Status.PodIP) > 0 && pod.DeletionTimestamp == nil {
+			if info, err := nodeInfo(nodeAddr(instance, pod.Status.PodIP), options); err == nil {
+				infos[pod.Status.PodIP] = info
+				node.NodeID = info["run_id"]
+				node.Role = info["role"]
+				if node.Role == crdv1alpha1.RoleMaster {
+					masters = append(masters, pod.Status.PodIP)
+				}
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	state := "fail"
+	master := ""
+	if len(masters) == 1 {
+		master = masters[0]
+		state = "ok"
+		for i := range nodes {
+			info, ok := infos[nodes[i].IP]
+			if !ok || nodes[i].IP == master {
+				continue
+			}
+			if infos[master] != nil {
+				nodes[i].MasterID = infos[master]["run_id"]
+			}
+			if info["master_host"] != master || info["master_link_status"] != "up" {
+				state = "fail"
+			}
+		}
+	}
+	return map[string]string{"cluster_state": state}, nodes, master
+}
+
+//根据采集到的状态计算phase和conditions
+func computeStatus(instance *crdv1alpha1.RedisCluster, status *crdv1alpha1.RedisClusterStatus, observed *observedState) {
+	desired := instance.Spec.GetClusterSize()
+
+	status.Nodes = observed.nodes
+	status.ClusterState = observed.clusterInfo["cluster_state"]
+	status.Replicas = 0
+	status.ReadyReplicas = 0
+	if observed.sts != nil {
+		status.Replicas = observed.sts.Status.Replicas
+		status.ReadyReplicas = observed.sts.Status.ReadyReplicas
+	}
+
+	bootstrapped := observed.clusterInfo["cluster_slots_assigned"] == strconv.Itoa(totalSlots)
+	if !instance.Spec.IsClusterMode() {
+		bootstrapped = len(observed.master) > 0
+	}
+	var lastJob *batchv1.Job
+	for i := range observed.jobs {
+		if observed.jobs[i].Labels[job.TypeLabel] == job.TypeCreate && jobSucceeded(&observed.jobs[i]) {
+			bootstrapped = true
+		}
+		lastJob = &observed.jobs[i]
+	}
+	scaling := lastJob != nil && lastJob.Labels[job.TypeLabel] == job.TypeScale && !jobFinished(lastJob)
+	if observed.sts != nil && observed.sts.Spec.Replicas != nil && *observed.sts.Spec.Replicas != desired {
+		scaling = true
+	}
+	op := status.Operation
+	opFailed := op.IsFailedAt(instance.Generation)
+	if op.IsRunning() && op.Type != crdv1alpha1.OperationCreate {
+		scaling = true
+	}
+	//计划还没有被批准时sts的副本数和spec不同，但是还没有开始扩缩容
+	if status.Plan != nil && !op.IsRunning() {
+		scaling = false
+	}
+
+	switch {
+	case bootstrapped && !instance.Spec.IsClusterMode():
+		setCondition(status, crdv1alpha1.RedisClusterConditionBootstrapped, corev1.ConditionTrue,
+			"MasterElected", "master is "+observed.master, instance.Generation)
+	case bootstrapped:
+		setCondition(status, crdv1alpha1.RedisClusterConditionBootstrapped, corev1.ConditionTrue,
+			"ClusterCreated", "all 16384 slots are assigned", instance.Generation)
+	case !instance.Spec.IsClusterMode():
+		setCondition(status, crdv1alpha1.RedisClusterConditionBootstrapped, corev1.ConditionFalse,
+			"NoMaster", "no single redis node reports role master", instance.Generation)
+	default:
+		setCondition(status, crdv1alpha1.RedisClusterConditionBootstrapped, corev1.ConditionFalse,
+			"ClusterNotCreated", "the cluster has not been created yet", instance.Generation)
+	}
+
+	switch {
+	case instance.Spec.Paused:
+		setCondition(status, crdv1alpha1.RedisClusterConditionPaused, corev1.ConditionTrue,
+			"PausedBySpec", "spec.paused is true, the operator does not change the cluster", instance.Generation)
+	case instance.IsPaused():
+		setCondition(status, crdv1alpha1.RedisClusterConditionPaused, corev1.ConditionTrue,
+			"PausedByAnnotation", crdv1alpha1.PausedAnnotation+" is true, the operator does not change the cluster",
+			instance.Generation)
+	default:
+		setCondition(status, crdv1alpha1.RedisClusterConditionPaused, corev1.ConditionFalse,
+			"NotPaused", "", instance.Generation)
+	}
+
+	if status.Plan != nil {
+		setCondition(status, crdv1alpha1.RedisClusterConditionPlanPending, corev1.ConditionTrue, "WaitingForApproval",
+			"set the annotation "+crdv1alpha1.ApprovedPlanAnnotation+"="+status.Plan.Name+" to start scaling", instance.Generation)
+	} else {
+		setCondition(status, crdv1alpha1.RedisClusterConditionPlanPending, corev1.ConditionFalse,
+			"NoPlan", "", instance.Generation)
+	}
+
+	if scaling {
+		setCondition(status, crdv1alpha1.RedisClusterConditionScaling, corev1.ConditionTrue,
+			"ScalingInProgress", "the cluster size is being changed", instance.Generation)
+	} else {
+		setCondition(status, crdv1alpha1.RedisClusterConditionScaling, corev1.ConditionFalse,
+			"NotScaling", "", instance.Generation)
+	}
+
+	healthy := bootstrapped && status.ClusterState == "ok" &&
+		status.ReadyReplicas == desired && countJoined(observed.nodes) == int(desired)
+
+	switch {
+	case observed.sts == nil:
+		status.Phase = crdv1alpha1.RedisClusterPhaseCreating
+	case opFailed, lastJob != nil && jobFailed(lastJob) && (op == nil || op.JobName == lastJob.Name):
+		status.Phase = crdv1alpha1.RedisClusterPhaseFailed
+	case scaling:
+		status.Phase = crdv1alpha1.RedisClusterPhaseScaling
+	case !bootstrapped && status.ReadyReplicas < desired:
+		status.Phase = crdv1alpha1.RedisClusterPhaseCreating
+	case !bootstrapped:
+		status.Phase = crdv1alpha1.RedisClusterPhaseBootstrapping
+	case healthy:
+		status.Phase = crdv1alpha1.RedisClusterPhaseReady
+	default:
+		status.Phase = crdv1alpha1.RedisClusterPhaseDegraded
+	}
+
+	switch {
+	case healthy:
+		setCondition(status, crdv1alpha1.RedisClusterConditionReady, corev1.ConditionTrue,
+			"ClusterReady", "", instance.Generation)
+	case opFailed:
+		setCondition(status, crdv1alpha1.RedisClusterConditionReady, corev1.ConditionFalse,
+			string(op.Type)+"Failed", op.Message, instance.Generation)
+	case lastJob != nil && jobFailed(lastJob):
+		setCondition(status, crdv1alpha1.RedisClusterConditionReady, corev1.ConditionFalse,
+			"JobFailed", "job "+lastJob.Name+" failed", instance.Generation)
+	case observed.clusterError != nil:
+		setCondition(status, crdv1alpha1.RedisClusterConditionReady, corev1.ConditionFalse,
+			"ClusterUnreachable", observed.clusterError.Error(), instance.Generation)
+	default:
+		setCondition(status, crdv1alpha1.RedisClusterConditionReady, corev1.ConditionFalse,
+			"ClusterNotReady", "cluster_state is "+status.ClusterState, instance.Generation)
+	}
+}
+
+//已经加入集群的节点个数
+func countJoined(nodes []crdv1alpha1.RedisClusterNode) int {
+	count := 0
+	for _, node := range nodes {
+		if len(node.NodeID) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+//更新一个condition，只有status发生变化的时候才更新LastTransitionTime
+func setCondition(status *crdv1alpha1.RedisClusterStatus, condType crdv1alpha1.RedisClusterConditionType,
+	condStatus corev1.ConditionStatus, reason, message string, generation int64) {
+	for i := range status.Conditions {
+		cond := &status.Conditions[i]
+		if cond.Type != condType {
+			continue
+		}
+		if cond.Status != condStatus {
+			cond.LastTransitionTime = metav1.Now()
+		}
+		cond.Status = condStatus
+		cond.Reason = reason
+		cond.Message = message
+		cond.ObservedGeneration = generation
+		return
+	}
+	status.Conditions = append(status.Conditions, crdv1alpha1.RedisClusterCondition{
+		Type:               condType,
+		Status:             condStatus,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+		LastTransitionTime: metav1.Now(),
+	})
+}
+
+func jobSucceeded(j *batchv1.Job) bool {
+	return jobHasCondition(j, batchv1.JobComplete)
+}
+
+func jobFailed(j *batchv1.Job) bool {
+	return jobHasCondition(j, batchv1.JobFailed)
+}
+
+func jobFinished(j *batchv1.Job) bool {
+	return jobSucceeded(j) || jobFailed(j)
+}
+
+func jobHasCondition(j *batchv1.Job, condType batchv1.JobConditionType) bool {
+	for _, cond := range j.Status.Conditions {
+		if cond.Type == condType && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+//statefulset的pod名字格式：rediscluster01-3，取最后的序号
+func podOrdinal(name string) int {
+	ordinal, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
+
+//检查一个元素是否存在于一个数组中
+func isElementExistsInArr(str string, arr []string) bool {
+	for _, value := range arr {
+		if value == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package rediscluster
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/utils/redisclient"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//删除RedisCluster之前，operator需要取消正在运行的job并按deletionPolicy处理PVC
+const redisClusterFinalizer = "crd.xzbc.com.cn/teardown"
+
+//k8s 1.14的external-snapshotter使用v1alpha1，snapshot的CRD不在client-go里，用unstructured访问
+const volumeSnapshotAPIVersion = "snapshot.storage.k8s.io/v1alpha1"
+
+func hasFinalizer(instance *crdv1alpha1.RedisCluster) bool {
+	return isElementExistsInArr(redisClusterFinalizer, instance.Finalizers)
+}
+
+func (r *ReconcileRedisCluster) addFinalizer(instance *crdv1alpha1.RedisCluster) error {
+	instance.Finalizers = append(instance.Finalizers, redisClusterFinalizer)
+	return r.client.Update(context.TODO(), instance)
+}
+
+//删除流程：取消job -> 按deletionPolicy处理PVC -> 去掉finalizer
+//statefulset、service等有ownerReference的资源在finalizer去掉之后由垃圾回收删除
+func (r *ReconcileRedisCluster) teardown(instance *crdv1alpha1.RedisCluster) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	if err := r.cancelOperation(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	policy := instance.Spec.GetDeletionPolicy()
+	switch policy {
+	case crdv1alpha1.DeletionPolicyDelete:
+		if err := r.deleteVolumes(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	case crdv1alpha1.DeletionPolicySnapshot:
+		ready, err := r.snapshotVolumes(instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !ready {
+			//snapshot一直不可用时，可以把deletionPolicy改成Retain或者Delete结束删除
+			return reconcile.Result{RequeueAfter: operationPollInterval}, nil
+		}
+		if err := r.deleteVolumes(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1alpha1.RedisCluster{}
+		err := r.client.Get(context.TODO(),
+			types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, latest)
+		if err != nil {
+			return err
+		}
+		var finalizers []string
+		for _, finalizer := range latest.Finalizers {
+			if finalizer != redisClusterFinalizer {
+				finalizers = append(finalizers, finalizer)
+			}
+		}
+		latest.Finalizers = finalizers
+		return r.client.Update(context.TODO(), latest)
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	reqLogger.Info("RedisCluster torn down", "deletionPolicy", policy)
+	forgetMetrics(instance)
+	return reconcile.Result{}, nil
+}
+
+//删除所有还在运行的create和scale job，job的pod一起删除
+//status.operation里正在进行的操作标记为Failed
+func (r *ReconcileRedisCluster) cancelOperation(instance *crdv1alpha1.RedisCluster) error {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"crd.xzbc.com.cn": instance.Name})
+	if err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if jobFinished(&jobs.Items[i]) || jobs.Items[i].DeletionTimestamp != nil {
+			continue
+		}
+		log.Info("Cancelling job", "Job.Namespace", instance.Namespace, "Job.Name", jobs.Items[i].Name)
+		err := r.client.Delete(context.TODO(), &jobs.Items[i],
+			client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, eventJobCancelled, "Cancelled job %s", jobs.Items[i].Name)
+	}
+
+	if op := instance.Status.Operation; op.IsRunning() {
+		_, err := r.failOperation(instance, op.DeepCopy(), "cancelled because the RedisCluster is being deleted")
+		return err
+	}
+	return nil
+}
+
+//volumeClaimTemplates创建的PVC名字是redis-data-<RedisCluster>-<序号>
+func (r *ReconcileRedisCluster) dataVolumes(instance *crdv1alpha1.RedisCluster) ([]corev1.PersistentVolumeClaim, error) {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	err := r.client.List(context.TODO(), pvcs, client.InNamespace(instance.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	prefix := "redis-data-" + instance.Name + "-"
+	var volumes []corev1.PersistentVolumeClaim
+	for _, pvc := range pvcs.Items {
+		if !strings.HasPrefix(pvc.Name, prefix) {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(pvc.Name, prefix)); err != nil {
+			continue
+		}
+		volumes = append(volumes, pvc)
+	}
+	return volumes, nil
+}
+
+//pod还在使用的PVC会等pod被垃圾回收删除之后才真正删除
+func (r *ReconcileRedisCluster) deleteVolumes(instance *crdv1alpha1.RedisCluster) error {
+	volumes, err := r.dataVolumes(instance)
+	if err != nil {
+		return err
+	}
+	for i := range volumes {
+		if volumes[i].DeletionTimestamp != nil {
+			continue
+		}
+		log.Info("Deleting data volume", "PVC.Namespace", instance.Namespace, "PVC.Name", volumes[i].Name)
+		if err := r.client.Delete(context.TODO(), &volumes[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, eventVolumeDeleted, "Deleted PVC %s", volumes[i].Name)
+	}
+	return nil
+}
+
+//给每个PVC创建一个VolumeSnapshot，全部readyToUse之后返回true
+//snapshot不设置ownerReference，RedisCluster删除之后仍然保留
+func (r *ReconcileRedisCluster) snapshotVolumes(instance *crdv1alpha1.RedisCluster) (bool, error) {
+	volumes, err := r.dataVolumes(instance)
+	if err != nil {
+		return false, err
+	}
+
+	ready := true
+	for _, pvc := range volumes {
+		name := fmt.Sprintf("%s-%d", pvc.Name, instance.DeletionTimestamp.Unix())
+		snapshot := &unstructured.Unstructured{}
+		snapshot.SetAPIVersion(volumeSnapshotAPIVersion)
+		snapshot.SetKind("VolumeSnapshot")
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: instance.Namespace}, snapshot)
+		if err != nil && errors.IsNotFound(err) {
+			//先把内存里的数据写到磁盘上，失败时snapshot里是最近一次持久化的数据
+			r.saveNode(instance, strings.TrimPrefix(pvc.Name, "redis-data-"))
+
+			snapshot = newVolumeSnapshot(instance, pvc.Name, name)
+			if err := r.client.Create(context.TODO(), snapshot); err != nil {
+				return false, err
+			}
+			log.Info("Created volume snapshot", "VolumeSnapshot.Namespace", instance.Namespace,
+				"VolumeSnapshot.Name", name)
+			r.recorder.Eventf(instance, corev1.EventTypeNormal, eventSnapshotCreated,
+				"Created VolumeSnapshot %s of PVC %s", name, pvc.Name)
+			ready = false
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		readyToUse, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+		if !readyToUse {
+			if message, ok, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); ok {
+				log.Info("Volume snapshot failed", "VolumeSnapshot.Namespace", instance.Namespace,
+					"VolumeSnapshot.Name", name, "message", message)
+				r.recorder.Eventf(instance, corev1.EventTypeWarning, eventSnapshotFailed,
+					"VolumeSnapshot %s failed: %s", name, message)
+			}
+			ready = false
+		}
+	}
+	return ready, nil
+}
+
+func newVolumeSnapshot(instance *crdv1alpha1.RedisCluster, pvcName, name string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"kind": "PersistentVolumeClaim",
+			"name": pvcName,
+		},
+	}
+	if len(instance.Spec.VolumeSnapshotClassName) > 0 {
+		spec["snapshotClassName"] = instance.Spec.VolumeSnapshotClassName
+	}
+	snapshot := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	snapshot.SetAPIVersion(volumeSnapshotAPIVersion)
+	snapshot.SetKind("VolumeSnapshot")
+	snapshot.SetName(name)
+	snapshot.SetNamespace(instance.Namespace)
+	snapshot.SetLabels(map[string]string{"crd.xzbc.com.cn": instance.Name})
+	return snapshot
+}
+
+//对使用这个PVC的pod执行SAVE，pod已经不在或者连接失败时只记录日志
+func (r *ReconcileRedisCluster) saveNode(instance *crdv1alpha1.RedisCluster, podName string) {
+	pod := &corev1.Pod{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: instance.Namespace}, pod)
+	if err != nil || len(pod.Status.PodIP) == 0 {
+		return
+	}
+	options, err := r.redisOptions(instance)
+	if err != nil {
+		log.Info("Cannot save redis data before the snapshot", "Pod", podName, "error", err.Error())
+		return
+	}
+	c, err := redisclient.DialWithOptions(nodeAddr(instance, pod.Status.PodIP), options)
+	if err != nil {
+		log.Info("Cannot save redis data before the snapshot", "Pod", podName, "error", err.Error())
+		return
+	}
+	defer c.Close()
+	if _, err := c.Do("SAVE"); err != nil {
+		log.Info("Cannot save redis data before the snapshot", "Pod", podName, "error", err.Error())
+	}
+}
@@ -0,0 +1,89 @@
+package rediscluster
+
+import (
+	"context"
+	"strconv"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/secret"
+	"xzbc-redis-cluster/pkg/resources/statefulset"
+	"xzbc-redis-cluster/pkg/resources/utils/redisclient"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+//读取spec.tls引用的secret，没有开启TLS的时候返回nil
+func (r *ReconcileRedisCluster) tlsSecret(instance *crdv1alpha1.RedisCluster) (*corev1.Secret, error) {
+	if !instance.Spec.TLSEnabled() {
+		return nil, nil
+	}
+	tlsSecret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(),
+		types.NamespacedName{Name: instance.Spec.TLS.SecretRef.Name, Namespace: instance.Namespace}, tlsSecret)
+	if err != nil {
+		return nil, err
+	}
+	return tlsSecret, nil
+}
+
+//operator连接开启了TLS的节点时，使用和节点相同的证书
+func (r *ReconcileRedisCluster) tlsConfig(instance *crdv1alpha1.RedisCluster, options *redisclient.Options) error {
+	tlsSecret, err := r.tlsSecret(instance)
+	if err != nil || tlsSecret == nil {
+		return err
+	}
+	options.TLSConfig, err = redisclient.NewTLSConfig(
+		tlsSecret.Data[instance.Spec.GetTLSCertKey()],
+		tlsSecret.Data[instance.Spec.GetTLSKeyKey()],
+		tlsSecret.Data[instance.Spec.GetTLSCAKey()])
+	return err
+}
+
+//controller里所有的statefulset都从这里生成
+//TLS证书保存在secret里，statefulset.New读不到，需要在这里把证书的hash设置到pod模板上
+func (r *ReconcileRedisCluster) desiredStatefulSet(instance *crdv1alpha1.RedisCluster) (*appsv1.StatefulSet, error) {
+	sts := statefulset.New(instance)
+	tlsSecret, err := r.tlsSecret(instance)
+	if err != nil {
+		return nil, err
+	}
+	if tlsSecret != nil {
+		sts.Spec.Template.Annotations[statefulset.TLSHashAnnotation] = secret.TLSHash(instance, tlsSecret)
+	}
+	return sts, nil
+}
+
+//redis不会重新加载证书文件，secret里的证书更新之后，修改pod模板上的hash触发滚动更新
+func (r *ReconcileRedisCluster) reconcileTLS(instance *crdv1alpha1.RedisCluster, found *appsv1.StatefulSet) error {
+	if !instance.Spec.TLSEnabled() {
+		return nil
+	}
+	sts, err := r.desiredStatefulSet(instance)
+	if err != nil {
+		return err
+	}
+	desiredHash := sts.Spec.Template.Annotations[statefulset.TLSHashAnnotation]
+	if found.Spec.Template.Annotations[statefulset.TLSHashAnnotation] == desiredHash {
+		return nil
+	}
+
+	log.Info("TLS certificate changed, rolling the redis pods",
+		"Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+	r.recorder.Event(instance, corev1.EventTypeNormal, eventCertRotated,
+		"TLS certificate changed, rolling the redis pods")
+	if found.Spec.Template.Annotations == nil {
+		found.Spec.Template.Annotations = map[string]string{}
+	}
+	found.Spec.Template.Annotations[statefulset.TLSHashAnnotation] = desiredHash
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.client.Update(context.TODO(), found)
+	})
+}
+
+//operator连接redis节点的地址，开启TLS之后使用TLS端口
+func nodeAddr(instance *crdv1alpha1.RedisCluster, podIP string) string {
+	return podIP + ":" + strconv.Itoa(int(instance.Spec.GetRedisPort()))
+}
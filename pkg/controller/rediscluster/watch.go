@@ -0,0 +1,89 @@
+package rediscluster
+
+import (
+	"reflect"
+	"strings"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//pod的owner是statefulset，通过label找到所属的RedisCluster
+//redis pod带有crd.xzbc.com.cn/v1alpha1，sentinel pod带有crd.xzbc.com.cn/sentinel
+type podMapper struct{}
+
+func (m *podMapper) Map(obj handler.MapObject) []reconcile.Request {
+	labels := obj.Meta.GetLabels()
+	name := labels["crd.xzbc.com.cn/v1alpha1"]
+	if len(name) == 0 {
+		name = labels[crdv1alpha1.SentinelLabel]
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: obj.Meta.GetNamespace()},
+	}}
+}
+
+//volumeClaimTemplates创建的pvc没有owner也没有label，名字是redis-data-<statefulset>-<序号>
+//redis statefulset和RedisCluster同名，去掉前缀和序号就是RedisCluster的名字
+type pvcMapper struct{}
+
+func (m *pvcMapper) Map(obj handler.MapObject) []reconcile.Request {
+	name := obj.Meta.GetName()
+	if !strings.HasPrefix(name, "redis-data-") || podOrdinal(name) < 0 {
+		return nil
+	}
+	name = strings.TrimPrefix(name[:strings.LastIndex(name, "-")], "redis-data-")
+	if len(name) == 0 {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: obj.Meta.GetNamespace()},
+	}}
+}
+
+//pod的status里探针时间之类的字段经常变化，只在会影响集群的变化时触发Reconcile：
+//ip、phase、ready、重启次数、label和删除
+var podChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, ok := e.ObjectOld.(*corev1.Pod)
+		if !ok {
+			return true
+		}
+		newPod, ok := e.ObjectNew.(*corev1.Pod)
+		if !ok {
+			return true
+		}
+		return oldPod.Status.PodIP != newPod.Status.PodIP ||
+			oldPod.Status.Phase != newPod.Status.Phase ||
+			podReady(oldPod) != podReady(newPod) ||
+			restartCount(oldPod) != restartCount(newPod) ||
+			!reflect.DeepEqual(oldPod.Labels, newPod.Labels) ||
+			(oldPod.DeletionTimestamp == nil) != (newPod.DeletionTimestamp == nil)
+	},
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func restartCount(pod *corev1.Pod) int32 {
+	var count int32
+	for _, status := range pod.Status.ContainerStatuses {
+		count += status.RestartCount
+	}
+	return count
+}
@@ -14,16 +14,6 @@ const (
 	//RedisConfigRelativePath = "redis.conf"
 )
 
-var redisConfig = `cluster-enabled yes
-cluster-config-file /data/nodes.conf
-cluster-node-timeout 5000
-cluster-migration-barrier 1
-dir /data
-appendonly yes
-protected-mode no
-`
-
-
 var fixIPConfig = `#!/bin/sh
     CLUSTER_CONFIG="/data/nodes.conf"
     if [ -f ${CLUSTER_CONFIG} ]; then
@@ -34,9 +24,24 @@ var fixIPConfig = `#!/bin/sh
       echo "Updating my IP to ${POD_IP} in ${CLUSTER_CONFIG}"
       sed -i.bak -e '/myself/ s/[0-9]\{1,3\}\.[0-9]\{1,3\}\.[0-9]\{1,3\}\.[0-9]\{1,3\}/'${POD_IP}'/' ${CLUSTER_CONFIG}
     fi
-    exec "$@"
+` + authConfigScript + `    exec "$@"
+`
+
+//开启认证时把密码写到只有redis用户能读的配置文件里，通过include加载
+//不能放在redis-server的命令行参数里，节点上的任何用户都能从ps和/proc/<pid>/cmdline看到
+//密码之后从环境变量里去掉，不传给redis-server
+var authConfigScript = `    if [ -n "${REDIS_PASSWORD}" ]; then
+      AUTH_CONFIG=/tmp/redis-auth.conf
+      ` + escapePasswordScript + `
+      (umask 077; printf 'requirepass "%s"\nmasterauth "%s"\n' "${PASSWORD}" "${PASSWORD}" > ${AUTH_CONFIG})
+      unset PASSWORD REDIS_PASSWORD
+      set -- "$@" --include ${AUTH_CONFIG}
+    fi
 `
 
+//配置文件里的密码用双引号括起来，密码里的\和"要转义，结果保存在PASSWORD里
+//redis和sentinel的配置文件都按这个格式解析，密码里有空格或者#也能正确读取
+var escapePasswordScript = `PASSWORD=$(printf '%s' "${REDIS_PASSWORD}" | sed -e 's/\\/\\\\/g' -e 's/"/\\"/g')`
 
 func New(redisCluster *v1alpha1.RedisCluster) *corev1.ConfigMap {
 	return &corev1.ConfigMap{
@@ -57,7 +62,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *corev1.ConfigMap {
 			Labels:map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
 		},
 		Data: map[string]string{
-			RedisConfigKey:redisConfig,
+			RedisConfigKey:RedisConfig(redisCluster),
 			FixIPKey:fixIPConfig,
 		},
 	}
@@ -0,0 +1,229 @@
+package configmap
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/secret"
+)
+
+type directive struct {
+	name  string
+	value string
+}
+
+//集群模式下operator内置的redis配置，写在defaultDirectives前面
+var clusterDirectives = []directive{
+	{"cluster-enabled", "yes"},
+	{"cluster-config-file", "/data/nodes.conf"},
+	{"cluster-node-timeout", "5000"},
+	{"cluster-migration-barrier", "1"},
+}
+
+//operator内置的redis配置，按这个顺序写入redis.conf
+//spec.config里的同名配置会覆盖这里的值
+var defaultDirectives = []directive{
+	{"dir", "/data"},
+	{"appendonly", "yes"},
+	{"protected-mode", "no"},
+}
+
+//operator依赖的配置，不允许通过spec.config修改
+var ProtectedDirectives = map[string]bool{
+	"cluster-enabled":     true,
+	"cluster-config-file": true,
+	"dir":                 true,
+	"protected-mode":      true,
+}
+
+//在集群模式或者容器里使用会出问题的配置，以及原因
+var UnsafeDirectives = map[string]string{
+	"port":                      "the service and cluster bus ports are managed by the operator",
+	"bind":                      "redis must listen on the pod IP",
+	"daemonize":                 "redis must run in the foreground of the container",
+	"slaveof":                   "replication is managed by the cluster",
+	"replicaof":                 "replication is managed by the cluster",
+	"include":                   "the configuration must be self-contained",
+	"rename-command":            "the operator relies on CLUSTER, CONFIG and MIGRATE",
+	"databases":                 "redis cluster only supports database 0",
+	"cluster-announce-ip":       "the pod IP is announced automatically",
+	"cluster-announce-port":     "the pod port is announced automatically",
+	"cluster-announce-bus-port": "the pod port is announced automatically",
+	"pidfile":                   "not used in a container",
+	"logfile":                   "redis must log to stdout",
+	"unixsocket":                "not used in a container",
+	"requirepass":               "passwords must not be stored in the CR",
+	"masterauth":                "passwords must not be stored in the CR",
+	"tls-port":                  "TLS is configured through spec.tls",
+	"tls-cert-file":             "TLS is configured through spec.tls",
+	"tls-key-file":              "TLS is configured through spec.tls",
+	"tls-ca-cert-file":          "TLS is configured through spec.tls",
+	"tls-ca-cert-dir":           "TLS is configured through spec.tls",
+	"tls-cluster":               "TLS is configured through spec.tls",
+	"tls-replication":           "TLS is configured through spec.tls",
+}
+
+//可以通过spec.config设置的配置
+var SupportedDirectives = map[string]bool{
+	"maxmemory":                       true,
+	"maxmemory-policy":                true,
+	"maxmemory-samples":               true,
+	"maxclients":                      true,
+	"timeout":                         true,
+	"tcp-keepalive":                   true,
+	"tcp-backlog":                     true,
+	"loglevel":                        true,
+	"hz":                              true,
+	"dynamic-hz":                      true,
+	"lua-time-limit":                  true,
+	"slowlog-log-slower-than":         true,
+	"slowlog-max-len":                 true,
+	"latency-monitor-threshold":       true,
+	"notify-keyspace-events":          true,
+	"appendonly":                      true,
+	"appendfsync":                     true,
+	"no-appendfsync-on-rewrite":       true,
+	"auto-aof-rewrite-percentage":     true,
+	"auto-aof-rewrite-min-size":       true,
+	"aof-load-truncated":              true,
+	"aof-use-rdb-preamble":            true,
+	"aof-rewrite-incremental-fsync":   true,
+	"save":                            true,
+	"stop-writes-on-bgsave-error":     true,
+	"rdbcompression":                  true,
+	"rdbchecksum":                     true,
+	"rdb-save-incremental-fsync":      true,
+	"cluster-node-timeout":            true,
+	"cluster-migration-barrier":       true,
+	"cluster-require-full-coverage":   true,
+	"cluster-slave-validity-factor":   true,
+	"cluster-replica-validity-factor": true,
+	"cluster-allow-reads-when-down":   true,
+	"slave-read-only":                 true,
+	"replica-read-only":               true,
+	"slave-serve-stale-data":          true,
+	"replica-serve-stale-data":        true,
+	"slave-priority":                  true,
+	"replica-priority":                true,
+	"repl-backlog-size":               true,
+	"repl-backlog-ttl":                true,
+	"repl-timeout":                    true,
+	"repl-diskless-sync":              true,
+	"repl-diskless-sync-delay":        true,
+	"repl-disable-tcp-nodelay":        true,
+	"min-slaves-to-write":             true,
+	"min-slaves-max-lag":              true,
+	"min-replicas-to-write":           true,
+	"min-replicas-max-lag":            true,
+	"client-output-buffer-limit":      true,
+	"hash-max-ziplist-entries":        true,
+	"hash-max-ziplist-value":          true,
+	"list-max-ziplist-size":           true,
+	"list-compress-depth":             true,
+	"set-max-intset-entries":          true,
+	"zset-max-ziplist-entries":        true,
+	"zset-max-ziplist-value":          true,
+	"hll-sparse-max-bytes":            true,
+	"stream-node-max-bytes":           true,
+	"stream-node-max-entries":         true,
+	"activerehashing":                 true,
+	"activedefrag":                    true,
+	"lazyfree-lazy-eviction":          true,
+	"lazyfree-lazy-expire":            true,
+	"lazyfree-lazy-server-del":        true,
+	"slave-lazy-flush":                true,
+	"replica-lazy-flush":              true,
+	"io-threads":                      true,
+	"io-threads-do-reads":             true,
+	"tls-auth-clients":                true,
+	"tls-protocols":                   true,
+	"tls-ciphers":                     true,
+	"tls-ciphersuites":                true,
+	"tls-prefer-server-ciphers":       true,
+}
+
+//开启TLS之后关闭明文端口，客户端、集群总线和主从复制都走TLS
+func tlsDirectives(redisCluster *v1alpha1.RedisCluster) []directive {
+	if !redisCluster.Spec.TLSEnabled() {
+		return nil
+	}
+	return []directive{
+		{"port", "0"},
+		{"tls-port", fmt.Sprintf("%d", redisCluster.Spec.GetRedisPort())},
+		{"tls-cert-file", secret.TLSCertFile},
+		{"tls-key-file", secret.TLSKeyFile},
+		{"tls-ca-cert-file", secret.TLSCAFile},
+		{"tls-cluster", "yes"},
+		{"tls-replication", "yes"},
+	}
+}
+
+//这些配置可以出现多次，spec.config里用换行分隔多个值
+var MultiValueDirectives = map[string]bool{
+	"save":                       true,
+	"client-output-buffer-limit": true,
+}
+
+//生成redis.conf，内置配置在前，spec.config里新增的配置按名字排序写在后面
+func RedisConfig(redisCluster *v1alpha1.RedisCluster) string {
+	userConfig := map[string]string{}
+	for name, value := range redisCluster.Spec.Config {
+		userConfig[strings.ToLower(name)] = value
+	}
+
+	directives := defaultDirectives
+	if redisCluster.Spec.IsClusterMode() {
+		directives = append(append([]directive{}, clusterDirectives...), defaultDirectives...)
+	}
+
+	var buf strings.Builder
+	for _, d := range directives {
+		value := d.value
+		if userValue, ok := userConfig[d.name]; ok && overridable(d.name) {
+			value = userValue
+		}
+		writeDirective(&buf, d.name, value)
+		delete(userConfig, d.name)
+	}
+	for _, d := range tlsDirectives(redisCluster) {
+		writeDirective(&buf, d.name, d.value)
+		delete(userConfig, d.name)
+	}
+
+	var names []string
+	for name := range userConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !overridable(name) {
+			continue
+		}
+		writeDirective(&buf, name, userConfig[name])
+	}
+	return buf.String()
+}
+
+//operator依赖的配置和不安全的配置即使绕过了webhook的校验也不会写入redis.conf
+func overridable(name string) bool {
+	_, unsafe := UnsafeDirectives[name]
+	return !ProtectedDirectives[name] && !unsafe
+}
+
+func writeDirective(buf *strings.Builder, name, value string) {
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		buf.WriteString(name + " " + line + "\n")
+	}
+}
+
+//redis.conf内容的hash，写在pod模板的annotation上，配置变化时触发statefulset滚动更新
+func ConfigHash(redisCluster *v1alpha1.RedisCluster) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(RedisConfig(redisCluster))))
+}
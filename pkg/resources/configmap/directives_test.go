@@ -0,0 +1,133 @@
+package configmap
+
+import (
+	"strings"
+	"testing"
+
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newRedisCluster(config map[string]string) *v1alpha1.RedisCluster {
+	redisCluster := &v1alpha1.RedisCluster{}
+	redisCluster.Name = "redis"
+	redisCluster.Spec.Config = config
+	return redisCluster
+}
+
+func TestRedisConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		tls    bool
+		//必须出现的行
+		want []string
+		//不能出现的行
+		notWant []string
+	}{
+		{
+			name:    "defaults",
+			want:    []string{"cluster-enabled yes", "cluster-config-file /data/nodes.conf", "dir /data", "appendonly yes"},
+			notWant: []string{"port 0"},
+		},
+		{
+			name:    "protected directives",
+			config:  map[string]string{"dir": "/tmp", "cluster-enabled": "no", "Cluster-Config-File": "/tmp/nodes.conf", "protected-mode": "yes"},
+			want:    []string{"dir /data", "cluster-enabled yes", "cluster-config-file /data/nodes.conf", "protected-mode no"},
+			notWant: []string{"dir /tmp", "cluster-enabled no", "cluster-config-file /tmp/nodes.conf", "protected-mode yes"},
+		},
+		{
+			name:    "unsafe directives",
+			config:  map[string]string{"port": "7000", "bind": "0.0.0.0", "requirepass": "secret", "Include": "/etc/redis.conf"},
+			notWant: []string{"port 7000", "bind 0.0.0.0", "requirepass secret", "include /etc/redis.conf"},
+		},
+		{
+			name:    "unsafe directives with tls",
+			config:  map[string]string{"port": "6379", "tls-port": "7000"},
+			tls:     true,
+			want:    []string{"port 0", "tls-port 6380", "tls-cluster yes"},
+			notWant: []string{"port 6379", "tls-port 7000"},
+		},
+		{
+			name: "supported directives",
+			config: map[string]string{
+				"appendonly":           "no",
+				"maxmemory":            "1gb",
+				"MaxMemory-Policy":     "allkeys-lru",
+				"save":                 "900 1\n300 10",
+				"cluster-node-timeout": "15000",
+			},
+			want: []string{"appendonly no", "maxmemory 1gb", "maxmemory-policy allkeys-lru", "save 900 1", "save 300 10",
+				"cluster-node-timeout 15000"},
+			notWant: []string{"appendonly yes", "cluster-node-timeout 5000"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redisCluster := newRedisCluster(test.config)
+			if test.tls {
+				redisCluster.Spec.TLS = &v1alpha1.RedisClusterTLS{SecretRef: corev1.LocalObjectReference{Name: "redis-tls"}}
+			}
+			lines := map[string]bool{}
+			for _, line := range strings.Split(RedisConfig(redisCluster), "\n") {
+				lines[line] = true
+			}
+			for _, line := range test.want {
+				if !lines[line] {
+					t.Errorf("missing %q", line)
+				}
+			}
+			for _, line := range test.notWant {
+				if lines[line] {
+					t.Errorf("unexpected %q", line)
+				}
+			}
+		})
+	}
+}
+
+//内置配置的顺序不变，用户新增的配置按名字排序写在后面
+func TestRedisConfigOrder(t *testing.T) {
+	config := RedisConfig(newRedisCluster(map[string]string{"timeout": "300", "appendonly": "no", "hz": "20"}))
+	want := "cluster-enabled yes\n" +
+		"cluster-config-file /data/nodes.conf\n" +
+		"cluster-node-timeout 5000\n" +
+		"cluster-migration-barrier 1\n" +
+		"dir /data\n" +
+		"appendonly no\n" +
+		"protected-mode no\n" +
+		"hz 20\n" +
+		"timeout 300\n"
+	if config != want {
+		t.Errorf("RedisConfig() =\n%s\nwant\n%s", config, want)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	//相同的配置以不同的顺序插入map
+	a := map[string]string{}
+	b := map[string]string{}
+	names := []string{"maxmemory", "timeout", "hz", "save", "loglevel", "tcp-keepalive"}
+	values := []string{"1gb", "300", "20", "900 1", "notice", "60"}
+	for i := range names {
+		a[names[i]] = values[i]
+		b[names[len(names)-1-i]] = values[len(names)-1-i]
+	}
+	hash := ConfigHash(newRedisCluster(a))
+	for i := 0; i < 10; i++ {
+		if got := ConfigHash(newRedisCluster(b)); got != hash {
+			t.Fatalf("hash changed with the insertion order: %s != %s", got, hash)
+		}
+	}
+
+	b["maxmemory"] = "2gb"
+	if ConfigHash(newRedisCluster(b)) == hash {
+		t.Errorf("hash did not change with the config")
+	}
+	//被忽略的配置不影响hash，不会触发滚动更新
+	a["port"] = "7000"
+	if ConfigHash(newRedisCluster(a)) != hash {
+		t.Errorf("hash changed with an ignored directive")
+	}
+}
@@ -0,0 +1,89 @@
+package configmap
+
+import (
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	StartRedisKey    = "start-redis.sh"
+	StartSentinelKey = "start-sentinel.sh"
+)
+
+//clustermode为false时redis pod的启动脚本
+//先向sentinel查询当前的master，sentinel还没有启动的时候，序号为0的pod作为master
+//环境变量由statefulset设置：POD_IP、MASTER_NAME、REDIS_SERVICE、SENTINEL_SERVICE、REDIS_PORT、SENTINEL_PORT、REDIS_PASSWORD
+var startRedisScript = `#!/bin/sh
+` + authConfigScript + `    MASTER=$(redis-cli -h ${SENTINEL_SERVICE} -p ${SENTINEL_PORT} sentinel get-master-addr-by-name ${MASTER_NAME} 2>/dev/null | head -n 1)
+    if [ -z "${MASTER}" ]; then
+      if [ "${HOSTNAME##*-}" = "0" ]; then
+        echo "No master known by sentinel, starting as master"
+        exec "$@"
+      fi
+      MASTER="${REDIS_SERVICE%%.*}-0.${REDIS_SERVICE}"
+    fi
+    if [ "${MASTER}" = "${POD_IP}" ]; then
+      echo "Sentinel reports this pod as master"
+      exec "$@"
+    fi
+    echo "Starting as replica of ${MASTER}"
+    exec "$@" --slaveof "${MASTER}" ${REDIS_PORT}
+`
+
+//sentinel的启动脚本，sentinel会改写自己的配置文件，所以配置文件生成在emptyDir里
+//配置文件里有密码，只有sentinel自己能读
+//其他sentinel已经在监控master的时候使用它们的结果，否则监控序号为0的redis pod
+var startSentinelScript = `#!/bin/sh
+    MASTER=$(redis-cli -h ${SENTINEL_SERVICE} -p ${SENTINEL_PORT} sentinel get-master-addr-by-name ${MASTER_NAME} 2>/dev/null | head -n 1)
+    while [ -z "${MASTER}" ]; do
+      MASTER=$(getent hosts "${REDIS_SERVICE%%.*}-0.${REDIS_SERVICE}" | awk '{print $1}')
+      if [ -z "${MASTER}" ]; then
+        echo "Waiting for the first redis pod"
+        sleep 2
+      fi
+    done
+    CONFIG=/sentinel/sentinel.conf
+    umask 077
+    echo "port ${SENTINEL_PORT}" > ${CONFIG}
+    echo "sentinel announce-ip ${POD_IP}" >> ${CONFIG}
+    echo "sentinel monitor ${MASTER_NAME} ${MASTER} ${REDIS_PORT} ${QUORUM}" >> ${CONFIG}
+    echo "sentinel down-after-milliseconds ${MASTER_NAME} 5000" >> ${CONFIG}
+    echo "sentinel failover-timeout ${MASTER_NAME} 60000" >> ${CONFIG}
+    echo "sentinel parallel-syncs ${MASTER_NAME} 1" >> ${CONFIG}
+    if [ -n "${REDIS_PASSWORD}" ]; then
+      ` + escapePasswordScript + `
+      printf 'sentinel auth-pass %s "%s"\n' "${MASTER_NAME}" "${PASSWORD}" >> ${CONFIG}
+      unset PASSWORD REDIS_PASSWORD
+    fi
+    exec redis-server ${CONFIG} --sentinel
+`
+
+//clustermode为false时使用的configMap，包含redis.conf和redis、sentinel的启动脚本
+func NewReplication(redisCluster *v1alpha1.RedisCluster) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      redisCluster.Name,
+			Namespace: redisCluster.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(redisCluster, schema.GroupVersionKind{
+					Group:   v1alpha1.SchemeGroupVersion.Group,
+					Version: v1alpha1.SchemeGroupVersion.Version,
+					Kind:    "RedisCluster",
+				}),
+			},
+			Labels: map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
+		},
+		Data: map[string]string{
+			RedisConfigKey:   RedisConfig(redisCluster),
+			StartRedisKey:    startRedisScript,
+			StartSentinelKey: startSentinelScript,
+		},
+	}
+}
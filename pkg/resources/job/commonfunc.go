@@ -1,18 +1,46 @@
 package job
 
 import (
-	"math/rand"
+	"fmt"
+	"hash/fnv"
+	"strconv"
 	"strings"
-	"time"
+
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/secret"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-//k8s的命名规范要求全小写的域名
-func RandString(len int) string {
-	r := rand.New(rand.NewSource(time.Now().Unix()))
-	bytes := make([]byte, len)
-	for i := 0; i < len; i++ {
-		b := r.Intn(26) + 65
-		bytes[i] = byte(b)
+//job的名字由集群、job类型、操作前后的pod个数和发起操作的generation决定
+//operator重启或者status没有写入时，同一个操作不会创建出第二个job
+//job controller会把名字写到pod的job-name label上，超过63个字符时截断集群名并加上hash
+func Name(redisCluster *v1alpha1.RedisCluster, jobType string, fromSize, toSize int32, generation int64) string {
+	suffix := fmt.Sprintf("-%s-%d-%d-g%d", jobType, fromSize, toSize, generation)
+	prefix := redisCluster.Name
+	if len(prefix)+len(suffix) > validation.DNS1123LabelMaxLength {
+		h := fnv.New32a()
+		h.Write([]byte(redisCluster.Name))
+		hash := fmt.Sprintf("-%08x", h.Sum32())
+		prefix = strings.TrimRight(prefix[:validation.DNS1123LabelMaxLength-len(suffix)-len(hash)], "-") + hash
+	}
+	return prefix + suffix
+}
+
+//job连接redis节点需要的环境变量：端口、密码和TLS证书
+//REDISCLI_AUTH沿用redis-cli的变量名，generate-script从这里读取密码
+func connectionEnv(redisCluster *v1alpha1.RedisCluster) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "REDIS_PORT", Value: strconv.Itoa(int(redisCluster.Spec.GetRedisPort()))},
+	}
+	env = append(env, secret.AuthEnv(redisCluster, "REDISCLI_AUTH")...)
+	if redisCluster.Spec.TLSEnabled() {
+		env = append(env,
+			corev1.EnvVar{Name: "REDIS_TLS_CERT", Value: secret.TLSCertFile},
+			corev1.EnvVar{Name: "REDIS_TLS_KEY", Value: secret.TLSKeyFile},
+			corev1.EnvVar{Name: "REDIS_TLS_CA", Value: secret.TLSCAFile},
+		)
 	}
-	return strings.ToLower(string(bytes))
+	return env
 }
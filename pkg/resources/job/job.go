@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/secret"
+	"xzbc-redis-cluster/pkg/resources/statefulset"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -11,16 +13,26 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-func New(redisCluser *v1alpha1.RedisCluster)  *batchv1.Job {
-	return &batchv1.Job{
+const (
+	//job的类型记录在这个label上，用于在controller里区分初始化和扩缩容的job
+	TypeLabel  = "crd.xzbc.com.cn/job-type"
+	TypeCreate = "create"
+	TypeScale  = "scale"
+)
+
+func New(redisCluser *v1alpha1.RedisCluster, jobName string)  *batchv1.Job {
+	job := &batchv1.Job{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Job",
 			APIVersion: "batch/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: redisCluser.Name + "-job-" + RandString(8),
+			Name: jobName,
 			Namespace: redisCluser.Namespace,
-			Labels:    map[string]string{"crd.xzbc.com.cn": redisCluser.Name},
+			Labels:    map[string]string{
+				"crd.xzbc.com.cn": redisCluser.Name,
+				TypeLabel:         TypeCreate,
+			},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(redisCluser, schema.GroupVersionKind{
 					Group:   v1alpha1.SchemeGroupVersion.Group,
@@ -34,27 +46,33 @@ func New(redisCluser *v1alpha1.RedisCluster)  *batchv1.Job {
 
 				Spec:corev1.PodSpec{
 					RestartPolicy:corev1.RestartPolicyNever,
+					Volumes:secret.TLSVolumes(redisCluser),
 					Containers: []corev1.Container{
 						{
 							Name:    "redis-trib-create",
 							Image: redisCluser.Spec.RedisTribImage,
 							ImagePullPolicy:corev1.PullIfNotPresent,
+							//generate-script直接连接redis节点创建集群，不再依赖redis-trib、expect和dig
 							Command:[]string{
-								"/bin/bash",
-								"-c",
-								"/tmp/generate-script && /tmp/redis-trib-create.sh",
+								"/tmp/generate-script",
 							},
-							Env:[]corev1.EnvVar{
+							Env:append([]corev1.EnvVar{
 								//通过Sprintf把int32转换成了string
-								{Name:"CLUSTER_SIZE",Value:fmt.Sprintf("%v",*redisCluser.Spec.Replicas)},
+								{Name:"CLUSTER_SIZE",Value:fmt.Sprintf("%v",redisCluser.Spec.GetClusterSize())},
 								{Name:"REDISCLUSTER_NAME",Value:redisCluser.Name},
 								{Name:"CLUSTER_OP_TYPE",Value:"create"},
 								{Name:"NAMESPACE",Value:redisCluser.Namespace},
-							},
+								{Name:"REPLICAS_PER_MASTER",Value:fmt.Sprintf("%v",redisCluser.Spec.GetReplicasPerShard())},
+							}, connectionEnv(redisCluser)...),
+							VolumeMounts:secret.TLSVolumeMounts(redisCluser),
 						},
 					},
 				},
 			},
 		},
 	}
+
+	//job和redis pod一样需要容忍专用节点的taint，从私有仓库拉取镜像
+	statefulset.ApplySchedulingOverrides(redisCluser, &job.Spec.Template.Spec)
+	return job
 }
@@ -1,24 +1,29 @@
 package job
 
 import (
+	"fmt"
+
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/secret"
+	"xzbc-redis-cluster/pkg/resources/statefulset"
 )
 
 func NewScaleJob(redisCluser *v1alpha1.RedisCluster,oldClusterSize,newClusterSize,jobName string)  *batchv1.Job {
-	return &batchv1.Job{
+	job := &batchv1.Job{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Job",
 			APIVersion: "batch/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: redisCluser.Name + "-job-" + jobName,
+			Name: jobName,
 			Namespace: redisCluser.Namespace,
 			Labels:    map[string]string{
 				"crd.xzbc.com.cn": redisCluser.Name,
+				TypeLabel:         TypeScale,
 			},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(redisCluser, schema.GroupVersionKind{
@@ -33,29 +38,32 @@ func NewScaleJob(redisCluser *v1alpha1.RedisCluster,oldClusterSize,newClusterSiz
 
 				Spec:corev1.PodSpec{
 					RestartPolicy:corev1.RestartPolicyNever,
+					Volumes:secret.TLSVolumes(redisCluser),
 					Containers: []corev1.Container{
 						{
 							Name:    "redis-trib-scale",
 							Image: redisCluser.Spec.RedisTribImage,
 							ImagePullPolicy:corev1.PullIfNotPresent,
 							Command:[]string{
-								"/bin/bash",
-								"-c",
-								//"/tmp/generate-script && tail -f /dev/null",
-								"/tmp/generate-script && /tmp/redis-trib-scale.sh",
+								"/tmp/generate-script",
 							},
-							Env:[]corev1.EnvVar{
+							Env:append([]corev1.EnvVar{
 								{Name:"REDISCLUSTER_NAME",Value:redisCluser.Name},
 								{Name:"CLUSTER_OP_TYPE",Value:"scale"},
 								{Name:"NAMESPACE",Value:redisCluser.Namespace},
 								{Name:"OLD_CLUSTER_SIZE",Value:oldClusterSize},
 								{Name:"NEW_CLUSTER_SIZE",Value:newClusterSize},
-							},
+								{Name:"REPLICAS_PER_MASTER",Value:fmt.Sprintf("%v",redisCluser.Spec.GetReplicasPerShard())},
+							}, connectionEnv(redisCluser)...),
+							VolumeMounts:secret.TLSVolumeMounts(redisCluser),
 						},
 					},
 				},
 			},
 		},
 	}
-}
 
+	//job和redis pod一样需要容忍专用节点的taint，从私有仓库拉取镜像
+	statefulset.ApplySchedulingOverrides(redisCluser, &job.Spec.Template.Spec)
+	return job
+}
@@ -0,0 +1,63 @@
+package secret
+
+import (
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	//applied secret里保存密码的key
+	AppliedPasswordKey = "password"
+)
+
+//operator自己维护的secret，记录当前已经设置到redis节点上的密码
+//用户修改了auth secret之后，需要用这个旧密码连接节点去修改requirepass和masterauth
+func AppliedAuthName(redisCluster *v1alpha1.RedisCluster) string {
+	return redisCluster.Name + "-applied-auth"
+}
+
+func NewAppliedAuth(redisCluster *v1alpha1.RedisCluster, password string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      AppliedAuthName(redisCluster),
+			Namespace: redisCluster.Namespace,
+			Labels:    map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(redisCluster, schema.GroupVersionKind{
+					Group:   v1alpha1.SchemeGroupVersion.Group,
+					Version: v1alpha1.SchemeGroupVersion.Version,
+					Kind:    "RedisCluster",
+				}),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			AppliedPasswordKey: []byte(password),
+		},
+	}
+}
+
+//从auth secret里读取密码的环境变量，没有开启认证的时候返回nil
+func AuthEnv(redisCluster *v1alpha1.RedisCluster, name string) []corev1.EnvVar {
+	if !redisCluster.Spec.AuthEnabled() {
+		return nil
+	}
+	return []corev1.EnvVar{
+		{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: redisCluster.Spec.Auth.SecretRef,
+					Key:                  redisCluster.Spec.GetAuthSecretKey(),
+				},
+			},
+		},
+	}
+}
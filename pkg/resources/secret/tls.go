@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	//TLS secret挂载到redis pod和job里的路径，文件名和secret里的key无关
+	//configMap挂载的/etc/redis是只读的，不能在它下面再挂载
+	TLSVolumeName = "redis-tls"
+	TLSMountPath  = "/etc/redis-tls"
+	TLSCertFile   = TLSMountPath + "/tls.crt"
+	TLSKeyFile    = TLSMountPath + "/tls.key"
+	TLSCAFile     = TLSMountPath + "/ca.crt"
+)
+
+//挂载TLS secret的volume，没有开启TLS的时候返回nil
+func TLSVolumes(redisCluster *v1alpha1.RedisCluster) []corev1.Volume {
+	if !redisCluster.Spec.TLSEnabled() {
+		return nil
+	}
+	return []corev1.Volume{
+		{
+			Name: TLSVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: redisCluster.Spec.TLS.SecretRef.Name,
+					Items: []corev1.KeyToPath{
+						{Key: redisCluster.Spec.GetTLSCertKey(), Path: "tls.crt"},
+						{Key: redisCluster.Spec.GetTLSKeyKey(), Path: "tls.key"},
+						{Key: redisCluster.Spec.GetTLSCAKey(), Path: "ca.crt"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TLSVolumeMounts(redisCluster *v1alpha1.RedisCluster) []corev1.VolumeMount {
+	if !redisCluster.Spec.TLSEnabled() {
+		return nil
+	}
+	return []corev1.VolumeMount{
+		{Name: TLSVolumeName, MountPath: TLSMountPath, ReadOnly: true},
+	}
+}
+
+//证书内容的hash，写在pod模板的annotation上，证书更新之后触发statefulset滚动更新让redis加载新证书
+func TLSHash(redisCluster *v1alpha1.RedisCluster, tlsSecret *corev1.Secret) string {
+	h := sha256.New()
+	for _, key := range []string{
+		redisCluster.Spec.GetTLSCertKey(),
+		redisCluster.Spec.GetTLSKeyKey(),
+		redisCluster.Spec.GetTLSCAKey(),
+	} {
+		h.Write(tlsSecret.Data[key])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -18,6 +18,7 @@ func NewClusterSvc(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
 		ObjectMeta:metav1.ObjectMeta{
 			Name:redisCluster.Name+"-svc",
 			Namespace:redisCluster.Namespace,
+			Labels:map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
 			OwnerReferences:[]metav1.OwnerReference{
 				*metav1.NewControllerRef(redisCluster,schema.GroupVersionKind{
 					Group:v1alpha1.SchemeGroupVersion.Group,
@@ -29,7 +30,8 @@ func NewClusterSvc(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
 		Spec:corev1.ServiceSpec{
 			Ports:[]corev1.ServicePort{
 				{
-					Port: 6379,
+					//开启TLS之后只暴露TLS端口
+					Port: redisCluster.Spec.GetRedisPort(),
 					Name: "redis",
 				},
 			},
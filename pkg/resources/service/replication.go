@@ -0,0 +1,82 @@
+package service
+
+import (
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+//clustermode为false时，始终指向当前master的service
+//operator根据sentinel的结果更新redis pod上的role label，故障转移之后service会跟着切换
+func NewMasterSvc(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      redisCluster.Name + "-master",
+			Namespace: redisCluster.Namespace,
+			Labels:    map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(redisCluster, schema.GroupVersionKind{
+					Group:   v1alpha1.SchemeGroupVersion.Group,
+					Version: v1alpha1.SchemeGroupVersion.Version,
+					Kind:    "RedisCluster",
+				}),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Port: redisCluster.Spec.GetRedisPort(),
+					Name: "redis",
+				},
+			},
+			Selector: map[string]string{
+				"crd.xzbc.com.cn/v1alpha1": redisCluster.Name,
+				v1alpha1.RoleLabel:         v1alpha1.RoleMaster,
+			},
+		},
+	}
+}
+
+//sentinel的headless service，sentinel statefulset和客户端都使用它
+func SentinelSvcName(redisCluster *v1alpha1.RedisCluster) string {
+	return redisCluster.Name + "-sentinel"
+}
+
+func NewSentinelSvc(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SentinelSvcName(redisCluster),
+			Namespace: redisCluster.Namespace,
+			Labels:    map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(redisCluster, schema.GroupVersionKind{
+					Group:   v1alpha1.SchemeGroupVersion.Group,
+					Version: v1alpha1.SchemeGroupVersion.Version,
+					Kind:    "RedisCluster",
+				}),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Port: v1alpha1.SentinelPort,
+					Name: "sentinel",
+				},
+			},
+			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				v1alpha1.SentinelLabel: redisCluster.Name,
+			},
+		},
+	}
+}
@@ -8,6 +8,21 @@ import (
 )
 
 func New(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
+	ports := []corev1.ServicePort{
+		{
+			//开启TLS之后只暴露TLS端口
+			Port: redisCluster.Spec.GetRedisPort(),
+			Name: "redis",
+		},
+	}
+	//只有集群模式有集群总线
+	if redisCluster.Spec.IsClusterMode() {
+		ports = append(ports, corev1.ServicePort{
+			Port: redisCluster.Spec.GetClusterBusPort(),
+			Name: "cluster",
+		})
+	}
+
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
@@ -16,6 +31,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
 		ObjectMeta:metav1.ObjectMeta{
 			Name:redisCluster.Name,
 			Namespace:redisCluster.Namespace,
+			Labels:map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
 			OwnerReferences:[]metav1.OwnerReference{
 				*metav1.NewControllerRef(redisCluster,schema.GroupVersionKind{
 					Group:v1alpha1.SchemeGroupVersion.Group,
@@ -25,16 +41,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *corev1.Service {
 			},
 		},
 		Spec:corev1.ServiceSpec{
-			Ports:[]corev1.ServicePort{
-				{
-					Port: 6379,
-					Name: "redis",
-				},
-				{
-					Port: 16379,
-					Name: "cluster",
-				},
-			},
+			Ports:ports,
 			ClusterIP:corev1.ClusterIPNone,
 			Selector: map[string]string{
 				"crd.xzbc.com.cn/v1alpha1":redisCluster.Name,
@@ -0,0 +1,60 @@
+package statefulset
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+)
+
+//把spec.podTemplate合并到生成的pod模板上
+//容器、volume和selector使用的label由operator维护，label和annotation冲突时以operator的值为准
+func ApplyPodTemplate(redisCluster *v1alpha1.RedisCluster, template *corev1.PodTemplateSpec) {
+	podTemplate := redisCluster.Spec.PodTemplate
+	if podTemplate == nil {
+		return
+	}
+
+	template.Labels = mergeMap(podTemplate.Labels, template.Labels)
+	template.Annotations = mergeMap(podTemplate.Annotations, template.Annotations)
+
+	spec := &template.Spec
+	if podTemplate.Affinity != nil {
+		spec.Affinity = podTemplate.Affinity.DeepCopy()
+	}
+	if podTemplate.SecurityContext != nil {
+		spec.SecurityContext = podTemplate.SecurityContext.DeepCopy()
+	}
+	if len(podTemplate.PriorityClassName) > 0 {
+		spec.PriorityClassName = podTemplate.PriorityClassName
+	}
+	ApplySchedulingOverrides(redisCluster, spec)
+}
+
+//job也需要调度到专用节点上并从私有仓库拉取镜像，只合并这几个字段
+func ApplySchedulingOverrides(redisCluster *v1alpha1.RedisCluster, spec *corev1.PodSpec) {
+	podTemplate := redisCluster.Spec.PodTemplate
+	if podTemplate == nil {
+		return
+	}
+	for _, toleration := range podTemplate.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, *toleration.DeepCopy())
+	}
+	if len(podTemplate.NodeSelector) > 0 {
+		spec.NodeSelector = mergeMap(spec.NodeSelector, podTemplate.NodeSelector)
+	}
+	spec.ImagePullSecrets = append(spec.ImagePullSecrets, podTemplate.ImagePullSecrets...)
+}
+
+//合并两个map，override里的值覆盖base里的值，两个都为空时返回nil
+func mergeMap(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range override {
+		result[k] = v
+	}
+	return result
+}
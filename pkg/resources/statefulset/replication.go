@@ -0,0 +1,160 @@
+package statefulset
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/configmap"
+	"xzbc-redis-cluster/pkg/resources/secret"
+	"xzbc-redis-cluster/pkg/resources/service"
+)
+
+const (
+	StartRedisRelativePath    = "start-redis.sh"
+	StartSentinelRelativePath = "start-sentinel.sh"
+)
+
+//clustermode为false时的redis statefulset，一个master和replicasPerShard个slave
+//存储、资源和配置都和集群模式一样，只是没有集群总线，启动时由start-redis.sh决定主从
+func NewReplication(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
+	sts := New(redisCluster)
+
+	container := &sts.Spec.Template.Spec.Containers[0]
+	container.Ports = []corev1.ContainerPort{
+		{Name: "redis", ContainerPort: redisCluster.Spec.GetRedisPort()},
+	}
+	container.Env = append(container.Env, replicationEnv(redisCluster)...)
+	container.Command = []string{
+		"/etc/redis/" + StartRedisRelativePath,
+		"redis-server",
+		"/etc/redis/redis.conf",
+		"--protected-mode no",
+	}
+
+	sts.Spec.Template.Spec.Volumes[0].ConfigMap.Items = []corev1.KeyToPath{
+		{Key: RedisConfigKey, Path: RedisConfigRelativePath},
+		{Key: configmap.StartRedisKey, Path: StartRedisRelativePath},
+	}
+	return sts
+}
+
+func SentinelName(redisCluster *v1alpha1.RedisCluster) string {
+	return redisCluster.Name + "-sentinel"
+}
+
+//监控master的sentinel，sentinel的配置文件放在emptyDir里，pod重建之后重新生成
+func NewSentinel(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
+	replicas := redisCluster.Spec.GetSentinelReplicas()
+	var resources corev1.ResourceRequirements
+	if redisCluster.Spec.Sentinel != nil {
+		resources = redisCluster.Spec.Sentinel.Resources
+	}
+
+	sts := &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Statefulset",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SentinelName(redisCluster),
+			Namespace: redisCluster.Namespace,
+			Labels:    map[string]string{"crd.xzbc.com.cn": redisCluster.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(redisCluster, schema.GroupVersionKind{
+					Group:   v1alpha1.SchemeGroupVersion.Group,
+					Version: v1alpha1.SchemeGroupVersion.Version,
+					Kind:    "RedisCluster",
+				}),
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			ServiceName: service.SentinelSvcName(redisCluster),
+			Replicas:    &replicas,
+			//sentinel之间没有启动顺序的要求
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					v1alpha1.SentinelLabel: redisCluster.Name,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						v1alpha1.SentinelLabel: redisCluster.Name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            "sentinel",
+							Image:           redisCluster.Spec.GetSentinelImage(),
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Resources:       resources,
+							Ports: []corev1.ContainerPort{
+								{Name: "sentinel", ContainerPort: v1alpha1.SentinelPort},
+							},
+							Env: append(append([]corev1.EnvVar{
+								{
+									Name: "POD_IP",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{
+											APIVersion: "v1",
+											FieldPath:  "status.podIP",
+										},
+									},
+								},
+								{Name: "QUORUM", Value: fmt.Sprintf("%d", redisCluster.Spec.GetSentinelQuorum())},
+							}, replicationEnv(redisCluster)...), secret.AuthEnv(redisCluster, "REDIS_PASSWORD")...),
+							Command: []string{"/etc/redis/" + StartSentinelRelativePath},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "redis-conf", MountPath: "/etc/redis"},
+								{Name: "sentinel-conf", MountPath: "/sentinel"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "redis-conf",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									Items: []corev1.KeyToPath{
+										{Key: configmap.StartSentinelKey, Path: StartSentinelRelativePath},
+									},
+									DefaultMode: &configMapMode,
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: redisCluster.Name,
+									},
+								},
+							},
+						},
+						{
+							Name: "sentinel-conf",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	//sentinel和redis运行在同样的节点上
+	ApplyPodTemplate(redisCluster, &sts.Spec.Template)
+	return sts
+}
+
+//redis和sentinel的启动脚本都需要的环境变量
+func replicationEnv(redisCluster *v1alpha1.RedisCluster) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "MASTER_NAME", Value: redisCluster.Name},
+		{Name: "REDIS_SERVICE", Value: redisCluster.Name + "." + redisCluster.Namespace + ".svc"},
+		{Name: "SENTINEL_SERVICE", Value: service.SentinelSvcName(redisCluster) + "." + redisCluster.Namespace + ".svc"},
+		{Name: "REDIS_PORT", Value: fmt.Sprintf("%d", redisCluster.Spec.GetRedisPort())},
+		{Name: "SENTINEL_PORT", Value: fmt.Sprintf("%d", v1alpha1.SentinelPort)},
+	}
+}
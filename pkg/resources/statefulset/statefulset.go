@@ -7,6 +7,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/configmap"
+	"xzbc-redis-cluster/pkg/resources/secret"
 )
 
 const (
@@ -14,12 +16,24 @@ const (
 	RedisConfigRelativePath = "redis.conf"
 	FixIPKey = "fix-ip.sh"
 	FixIPRelativePath = "fix-ip.sh"
+	ConfigHashAnnotation = "crd.xzbc.com.cn/config-hash"
+	//TLS证书的hash，由controller读取secret之后设置
+	TLSHashAnnotation = "crd.xzbc.com.cn/tls-hash"
 )
 
 var configMapMode = int32(0755)
 
 func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
-	return &appsv1.StatefulSet{
+	//pod总数由shards和replicasPerShard计算出来
+	clusterSize := redisCluster.Spec.GetClusterSize()
+
+	//storageClassName为空的时候使用集群默认的StorageClass，不能传一个空字符串的指针
+	var storageClassName *string
+	if len(redisCluster.Spec.StorageClassName) > 0 {
+		storageClassName = &redisCluster.Spec.StorageClassName
+	}
+
+	sts := &appsv1.StatefulSet{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Statefulset",
 			APIVersion: "apps/v1",
@@ -39,7 +53,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 		Spec: appsv1.StatefulSetSpec{
 			//这个service是headless的svc
 			ServiceName: redisCluster.Name,
-			Replicas:    redisCluster.Spec.Replicas,
+			Replicas:    &clusterSize,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"crd.xzbc.com.cn/v1alpha1": redisCluster.Name,
@@ -51,6 +65,10 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 					Labels: map[string]string{
 						"crd.xzbc.com.cn/v1alpha1": redisCluster.Name,
 					},
+					//redis只在启动时读取配置文件，配置变化之后通过滚动更新让pod使用新的配置
+					Annotations: map[string]string{
+						ConfigHashAnnotation: configmap.ConfigHash(redisCluster),
+					},
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
@@ -60,11 +78,13 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Resources:       redisCluster.Spec.Resources,
 							//redis里port有多个，6379用于服务监听, 用于集群通信的16379
+							//开启TLS之后分别是6380和16380
 							Ports: []corev1.ContainerPort{
-								{Name: "redis", ContainerPort: 6379,},
-								{Name: "cluster", ContainerPort: 16379,},
+								{Name: "redis", ContainerPort: redisCluster.Spec.GetRedisPort(),},
+								{Name: "cluster", ContainerPort: redisCluster.Spec.GetClusterBusPort(),},
 							},
-							Env: []corev1.EnvVar{
+							//开启认证时，fix-ip.sh用这个变量生成设置requirepass和masterauth的配置文件
+							Env: append([]corev1.EnvVar{
 								{
 									Name:"POD_IP",
 									ValueFrom:&corev1.EnvVarSource{
@@ -74,11 +94,11 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 										},
 									},
 								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
+							}, secret.AuthEnv(redisCluster, "REDIS_PASSWORD")...),
+							VolumeMounts: append([]corev1.VolumeMount{
 								{Name: "redis-conf", MountPath: "/etc/redis"},
 								{Name: "redis-data", MountPath: "/data"},
-							},
+							}, secret.TLSVolumeMounts(redisCluster)...),
 							Command: []string{
 								"/etc/redis/fix-ip.sh",
 								"redis-server",
@@ -98,7 +118,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 							//},
 						},
 					},
-					Volumes: []corev1.Volume{
+					Volumes: append([]corev1.Volume{
 						/*
 						{
 
@@ -123,7 +143,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 								},
 							},
 						},
-					},
+					}, secret.TLSVolumes(redisCluster)...),
 				},
 			},
 			// 如果需要在本地测试，没有共享存储环境，需要使用emptyDir{}
@@ -136,7 +156,7 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 						},
 						Spec:corev1.PersistentVolumeClaimSpec{
 							AccessModes:[]corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-							StorageClassName:&redisCluster.Spec.StorageClassName,
+							StorageClassName:storageClassName,
 							Resources:corev1.ResourceRequirements{
 								Requests:corev1.ResourceList{
 									corev1.ResourceStorage:resource.MustParse(
@@ -149,4 +169,8 @@ func New(redisCluster *v1alpha1.RedisCluster) *appsv1.StatefulSet {
 				},
 		},
 	}
-}
\ No newline at end of file
+
+	//用户在spec.podTemplate里设置的调度和安全配置
+	ApplyPodTemplate(redisCluster, &sts.Spec.Template)
+	return sts
+}
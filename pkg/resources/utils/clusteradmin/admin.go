@@ -0,0 +1,444 @@
+package clusteradmin
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"xzbc-redis-cluster/pkg/resources/utils/rebalance"
+	"xzbc-redis-cluster/pkg/resources/utils/redisclient"
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+)
+
+const (
+	//等待节点握手、复制和配置传播的最长时间
+	defaultWaitTimeout = 2 * time.Minute
+	//每次GETKEYSINSLOT/MIGRATE迁移的key的个数
+	migrateBatch = 100
+	//单次MIGRATE的超时时间
+	migrateTimeout = 60 * time.Second
+)
+
+//Admin直接用RESP协议连接集群里的节点执行create、add-node、reshard和del-node
+//取代redis-trib和redis-cli --cluster，每一步失败都返回具体的错误
+//每个节点只保持一个连接，不是并发安全的
+type Admin struct {
+	port    int
+	options redisclient.Options
+	clients map[string]*redisclient.Client
+	//等待集群收敛的最长时间，为0时使用defaultWaitTimeout
+	WaitTimeout time.Duration
+	//打印执行过程，为nil时不打印
+	Logf func(format string, args ...interface{})
+}
+
+//port是所有节点的redis端口，开启TLS时是6380
+func New(port int, options redisclient.Options) *Admin {
+	return &Admin{
+		port:    port,
+		options: options,
+		clients: map[string]*redisclient.Client{},
+	}
+}
+
+func (a *Admin) Close() {
+	for ip, c := range a.clients {
+		c.Close()
+		delete(a.clients, ip)
+	}
+}
+
+func (a *Admin) logf(format string, args ...interface{}) {
+	if a.Logf != nil {
+		a.Logf(format, args...)
+	}
+}
+
+func (a *Admin) addr(ip string) string {
+	return net.JoinHostPort(ip, strconv.Itoa(a.port))
+}
+
+//执行一组命令，网络错误时关闭连接，下一次重新建立
+func (a *Admin) do(ip string, f func(c *redisclient.Client) error) error {
+	c, ok := a.clients[ip]
+	if !ok {
+		var err error
+		c, err = redisclient.DialWithOptions(a.addr(ip), a.options)
+		if err != nil {
+			return err
+		}
+		a.clients[ip] = c
+	}
+	err := f(c)
+	if _, isRedisError := err.(redisclient.Error); err != nil && !isRedisError {
+		c.Close()
+		delete(a.clients, ip)
+	}
+	return err
+}
+
+//从ip这个节点看到的CLUSTER NODES的原始输出
+func (a *Admin) ClusterNodes(ip string) (string, error) {
+	var nodes string
+	err := a.do(ip, func(c *redisclient.Client) error {
+		var err error
+		nodes, err = c.ClusterNodes()
+		return err
+	})
+	return nodes, err
+}
+
+//从ip这个节点看到的集群拓扑
+func (a *Admin) Topology(ip string) (*topology.Topology, error) {
+	nodes, err := a.ClusterNodes(ip)
+	if err != nil {
+		return nil, err
+	}
+	return topology.Parse(nodes)
+}
+
+//节点自己在CLUSTER NODES里的那一行
+func (a *Admin) myself(ip string) (*topology.Node, error) {
+	t, err := a.Topology(ip)
+	if err != nil {
+		return nil, err
+	}
+	me := t.Myself()
+	if me == nil {
+		return nil, fmt.Errorf("no myself line in CLUSTER NODES of %s", ip)
+	}
+	return me, nil
+}
+
+func (a *Admin) clusterInfo(ip string) (map[string]string, error) {
+	var info map[string]string
+	err := a.do(ip, func(c *redisclient.Client) error {
+		var err error
+		info, err = c.ClusterInfo()
+		return err
+	})
+	return info, err
+}
+
+//节点在集群中的id
+func (a *Admin) NodeID(ip string) (string, error) {
+	me, err := a.myself(ip)
+	if err != nil {
+		return "", err
+	}
+	return me.ID, nil
+}
+
+//用空节点创建集群，每replicasPerMaster+1个节点一组，每组的第一个是master，其余的是这个master的slave
+//16384个slot平均分配给所有的master
+//阻塞到集群创建完成，job重新运行时已经完成的步骤会跳过
+func (a *Admin) Create(ips []string, replicasPerMaster int) error {
+	if _, err := createMasters(ips, replicasPerMaster); err != nil {
+		return err
+	}
+	return a.wait("the cluster to be created", func() (bool, error) {
+		return a.CreateStep(ips, replicasPerMaster)
+	})
+}
+
+//Create的一步，不等待握手、复制和配置传播，已经完成的命令不再执行
+//集群创建完成之后返回true，否则过一段时间再调用，operator每次Reconcile调用一次
+func (a *Admin) CreateStep(ips []string, replicasPerMaster int) (bool, error) {
+	masters, err := createMasters(ips, replicasPerMaster)
+	if err != nil {
+		return false, err
+	}
+	for i, ip := range masters {
+		me, err := a.myself(ip)
+		if err != nil {
+			return false, err
+		}
+		if me.SlotCount() > 0 {
+			continue
+		}
+		start := i * topology.TotalSlots / len(masters)
+		end := (i+1)*topology.TotalSlots/len(masters) - 1
+		a.logf("Assigning slots %d-%d to %s", start, end, ip)
+		err = a.do(ip, func(c *redisclient.Client) error {
+			//每个master的config epoch不同，避免启动时的epoch冲突
+			if err := c.ClusterSetConfigEpoch(int64(i + 1)); err != nil {
+				a.logf("Cannot set the config epoch of %s: %v", ip, err)
+			}
+			slots := make([]int, 0, end-start+1)
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+			return c.ClusterAddSlots(slots...)
+		})
+		if err != nil {
+			return false, fmt.Errorf("assign slots %d-%d to %s: %v", start, end, ip, err)
+		}
+	}
+
+	for _, ip := range ips[1:] {
+		if err := a.meet(ip, ips[0]); err != nil {
+			return false, err
+		}
+	}
+	if known, err := a.knowsAll(ips); err != nil || !known {
+		return false, err
+	}
+
+	groupSize := replicasPerMaster + 1
+	replicated := true
+	for i, ip := range ips {
+		if i%groupSize == 0 {
+			continue
+		}
+		masterID, err := a.NodeID(ips[i-i%groupSize])
+		if err != nil {
+			return false, err
+		}
+		done, err := a.replicateOnce(ip, masterID)
+		if err != nil {
+			return false, err
+		}
+		replicated = replicated && done
+	}
+	if !replicated {
+		return false, nil
+	}
+	return a.Consistent(ips)
+}
+
+//每组的第一个节点
+func createMasters(ips []string, replicasPerMaster int) ([]string, error) {
+	groupSize := replicasPerMaster + 1
+	if len(ips) == 0 || len(ips)%groupSize != 0 {
+		return nil, fmt.Errorf("cannot create a cluster of %d nodes with %d replicas per master", len(ips), replicasPerMaster)
+	}
+	var masters []string
+	for i := 0; i < len(ips); i += groupSize {
+		masters = append(masters, ips[i])
+	}
+	return masters, nil
+}
+
+//新节点和集群里的节点握手，已经在集群里的节点不再执行
+func (a *Admin) meet(ip, entryIP string) error {
+	info, err := a.clusterInfo(ip)
+	if err != nil {
+		return err
+	}
+	if info["cluster_known_nodes"] != "1" {
+		return nil
+	}
+	a.logf("Adding %s to the cluster through %s", ip, entryIP)
+	err = a.do(ip, func(c *redisclient.Client) error {
+		return c.ClusterMeet(entryIP, a.port)
+	})
+	if err != nil {
+		return fmt.Errorf("meet %s with %s: %v", ip, entryIP, err)
+	}
+	return nil
+}
+
+//把ip设置为masterID的slave
+//新节点要等握手完成、知道master之后才能REPLICATE，未知节点的错误重试到超时
+func (a *Admin) Replicate(ip, masterID string) error {
+	return a.wait(fmt.Sprintf("%s to replicate %s", ip, masterID), func() (bool, error) {
+		return a.replicateOnce(ip, masterID)
+	})
+}
+
+//ip已经是masterID的slave时返回true，否则执行一次CLUSTER REPLICATE
+//还不认识masterID时返回false，等握手完成之后再调用
+func (a *Admin) replicateOnce(ip, masterID string) (bool, error) {
+	me, err := a.myself(ip)
+	if err != nil {
+		return false, err
+	}
+	if me.MasterID == masterID {
+		return true, nil
+	}
+	a.logf("Setting %s as a replica of %s", ip, masterID)
+	err = a.do(ip, func(c *redisclient.Client) error {
+		return c.ClusterReplicate(masterID)
+	})
+	if _, ok := err.(redisclient.Error); ok {
+		a.logf("Cannot replicate %s yet: %v", masterID, err)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//把slot从source迁移到target，已经属于target的slot跳过
+//每个slot：target IMPORTING -> source MIGRATING -> 分批MIGRATE所有key -> 两边SETSLOT NODE
+func (a *Admin) MoveSlots(sourceIP, targetIP string, slots []int) error {
+	source, err := a.myself(sourceIP)
+	if err != nil {
+		return err
+	}
+	target, err := a.myself(targetIP)
+	if err != nil {
+		return err
+	}
+	a.logf("Moving %d slots from %s to %s", len(slots), sourceIP, targetIP)
+	for _, slot := range slots {
+		if !source.OwnsSlot(slot) {
+			continue
+		}
+		if err := a.moveSlot(sourceIP, targetIP, source.ID, target.ID, slot); err != nil {
+			return fmt.Errorf("move slot %d from %s to %s: %v", slot, sourceIP, targetIP, err)
+		}
+	}
+	return nil
+}
+
+//按顺序执行rebalance计划里的迁移，计划里节点的名字是ip
+func (a *Admin) ApplyPlan(plan *rebalance.Plan) error {
+	for _, m := range plan.Moves {
+		if err := a.MoveSlots(m.Source, m.Target, m.SlotList()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Admin) moveSlot(sourceIP, targetIP, sourceID, targetID string, slot int) error {
+	err := a.do(targetIP, func(c *redisclient.Client) error {
+		return c.ClusterSetSlotImporting(slot, sourceID)
+	})
+	if err != nil {
+		return err
+	}
+	err = a.do(sourceIP, func(c *redisclient.Client) error {
+		if err := c.ClusterSetSlotMigrating(slot, targetID); err != nil {
+			return err
+		}
+		for {
+			keys, err := c.ClusterGetKeysInSlot(slot, migrateBatch)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return nil
+			}
+			err = c.Migrate(targetIP, a.port, keys, redisclient.MigrateOptions{
+				Password: a.options.Password,
+				Timeout:  migrateTimeout,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	//先在target上设置，target的epoch增加之后新的归属会通过gossip传播到其他节点
+	for _, ip := range []string{targetIP, sourceIP} {
+		err := a.do(ip, func(c *redisclient.Client) error {
+			return c.ClusterSetSlotNode(slot, targetID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//把节点从集群中移除：其他节点FORGET这个节点，然后这个节点RESET，忘记其他所有节点
+//master上的slot必须已经迁移走，它的slave必须先移除
+//ip为空表示节点已经不可用，只在其他节点上执行FORGET
+func (a *Admin) DelNode(clusterIPs []string, ip, nodeID string) error {
+	a.logf("Removing node %s (%s) from the cluster", nodeID, ip)
+	for _, other := range clusterIPs {
+		if other == ip {
+			continue
+		}
+		err := a.do(other, func(c *redisclient.Client) error {
+			return c.ClusterForget(nodeID)
+		})
+		//已经不认识这个节点了
+		if e, ok := err.(redisclient.Error); ok && strings.Contains(string(e), "Unknown node") {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("forget %s on %s: %v", nodeID, other, err)
+		}
+	}
+	if len(ip) == 0 {
+		return nil
+	}
+	err := a.do(ip, func(c *redisclient.Client) error {
+		return c.ClusterResetSoft()
+	})
+	if err != nil {
+		return fmt.Errorf("reset %s: %v", ip, err)
+	}
+	return nil
+}
+
+//等待所有节点的cluster_state都是ok，并且认识的节点个数一致
+func (a *Admin) WaitForConsistency(ips []string) error {
+	return a.wait("the cluster to become consistent", func() (bool, error) {
+		return a.Consistent(ips)
+	})
+}
+
+//所有节点的cluster_state都是ok，所有slot都已分配，并且认识的节点个数一致，只检查一次
+func (a *Admin) Consistent(ips []string) (bool, error) {
+	knownNodes := ""
+	for _, ip := range ips {
+		info, err := a.clusterInfo(ip)
+		if err != nil {
+			return false, err
+		}
+		if info["cluster_state"] != "ok" || info["cluster_slots_assigned"] != strconv.Itoa(topology.TotalSlots) {
+			return false, nil
+		}
+		if len(knownNodes) > 0 && info["cluster_known_nodes"] != knownNodes {
+			return false, nil
+		}
+		knownNodes = info["cluster_known_nodes"]
+	}
+	return true, nil
+}
+
+//每个节点都认识ips里所有的节点，并且认识的节点个数一致，只检查一次
+func (a *Admin) knowsAll(ips []string) (bool, error) {
+	known := 0
+	for _, ip := range ips {
+		info, err := a.clusterInfo(ip)
+		if err != nil {
+			return false, err
+		}
+		n, _ := strconv.Atoi(info["cluster_known_nodes"])
+		if known > 0 && n != known || n < len(ips) {
+			return false, nil
+		}
+		known = n
+	}
+	return true, nil
+}
+
+//每秒检查一次，网络错误也重试，超时之后返回最后一次的错误
+//会阻塞调用者，只在job里使用，operator使用只检查一次的CreateStep、JoinStep、RemoveStep和Consistent
+func (a *Admin) wait(what string, check func() (bool, error)) error {
+	timeout := a.WaitTimeout
+	if timeout == 0 {
+		timeout = defaultWaitTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := check()
+		if done && err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out waiting for %s: %v", what, err)
+			}
+			return fmt.Errorf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Second)
+	}
+}
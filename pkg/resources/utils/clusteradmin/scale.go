@@ -0,0 +1,168 @@
+package clusteradmin
+
+import (
+	"errors"
+	"fmt"
+
+	"xzbc-redis-cluster/pkg/resources/utils/rebalance"
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+)
+
+//扩缩容的几个步骤，job和operator都按这个顺序调用
+//ips是按pod序号排列的所有节点的ip，每一步都可以在中断之后重新执行
+
+//把序号在[from, to)之间的新节点加入集群
+//按replicasPerMaster+1个一组，每组的第一个作为master加入集群，其余的是这个master的slave
+func (a *Admin) JoinNodes(ips []string, from, to, replicasPerMaster int) error {
+	return a.wait("the new nodes to join the cluster", func() (bool, error) {
+		return a.JoinStep(ips, from, to, replicasPerMaster)
+	})
+}
+
+//JoinNodes的一步，不等待握手和复制，已经完成的命令不再执行
+//所有新节点都加入集群并且slave都已经复制各自的master之后返回true
+func (a *Admin) JoinStep(ips []string, from, to, replicasPerMaster int) (bool, error) {
+	//集群中已有的第一个节点，新节点通过它加入集群
+	entryIP := ips[0]
+	for _, ip := range ips[from:to] {
+		if err := a.meet(ip, entryIP); err != nil {
+			return false, err
+		}
+	}
+	if known, err := a.knowsAll(append([]string{entryIP}, ips[from:to]...)); err != nil || !known {
+		return false, err
+	}
+
+	groupSize := replicasPerMaster + 1
+	replicated := true
+	for i := from; i < to; i++ {
+		if (i-from)%groupSize == 0 {
+			continue
+		}
+		//这一组的master，后面的slave都挂到这个master上
+		masterID, err := a.NodeID(ips[i-(i-from)%groupSize])
+		if err != nil {
+			return false, err
+		}
+		done, err := a.replicateOnce(ips[i], masterID)
+		if err != nil {
+			return false, err
+		}
+		replicated = replicated && done
+	}
+	return replicated, nil
+}
+
+//把slot平均分配给前size个节点里的master的计划，其余master上的slot会被全部迁走
+//计划里节点的名字是ip，可以直接交给ApplyPlan执行
+func (a *Admin) RebalancePlan(ips []string, size int) (*rebalance.Plan, error) {
+	t, err := a.Topology(ips[0])
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, ip := range ips[:size] {
+		if node := t.NodeByIP(ip); node != nil && node.IsMaster() {
+			targets = append(targets, ip)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no master among the first %d nodes", size)
+	}
+	shards, err := rebalance.EvenByIP(t, targets)
+	if err != nil {
+		return nil, err
+	}
+	return rebalance.Compute(shards)
+}
+
+//把序号不小于size的节点移出集群，它们上面的slot必须已经迁走
+//保留下来的slave如果复制的是要移除的master，先改为复制保留下来的slave最少的master
+//再从序号最大的节点开始移除，先移除slave，再移除master
+func (a *Admin) RemoveNodes(ips []string, size int) error {
+	return a.wait("the removed nodes to leave the cluster", func() (bool, error) {
+		return a.RemoveStep(ips, size)
+	})
+}
+
+//RemoveNodes的一步，不等待复制和FORGET传播，已经完成的命令不再执行
+//ips[0]看到的集群里已经没有被移除的节点时返回true
+func (a *Admin) RemoveStep(ips []string, size int) (bool, error) {
+	t, err := a.Topology(ips[0])
+	if err != nil {
+		return false, err
+	}
+	kept := map[string]bool{}
+	for _, ip := range ips[:size] {
+		kept[ip] = true
+	}
+	var keptMasters []*topology.Node
+	for _, master := range t.Masters() {
+		if kept[master.IP] {
+			keptMasters = append(keptMasters, master)
+		}
+	}
+	for _, ip := range ips[size:] {
+		if node := t.NodeByIP(ip); node != nil && node.IsMaster() && node.SlotCount() > 0 {
+			return false, fmt.Errorf("master %s still has %d slots", ip, node.SlotCount())
+		}
+	}
+
+	//每个master上保留下来的slave个数
+	replicaCount := map[string]int{}
+	for _, ip := range ips[:size] {
+		if node := t.NodeByIP(ip); node != nil && node.IsReplica() {
+			replicaCount[node.MasterID]++
+		}
+	}
+	replicated := true
+	for _, ip := range ips[:size] {
+		node := t.NodeByIP(ip)
+		if node == nil || !node.IsReplica() {
+			continue
+		}
+		//以slave自己看到的master为准，上一步执行的REPLICATE可能还没有传播到ips[0]
+		me, err := a.myself(ip)
+		if err != nil {
+			return false, err
+		}
+		if master := t.Node(me.MasterID); master != nil && kept[master.IP] {
+			continue
+		}
+		var target *topology.Node
+		for _, master := range keptMasters {
+			if target == nil || replicaCount[master.ID] < replicaCount[target.ID] {
+				target = master
+			}
+		}
+		if target == nil {
+			return false, errors.New("no master left after scaling down")
+		}
+		done, err := a.replicateOnce(ip, target.ID)
+		if err != nil {
+			return false, err
+		}
+		replicated = replicated && done
+		replicaCount[node.MasterID]--
+		replicaCount[target.ID]++
+	}
+	//slave都挂到保留下来的master上之后再移除节点
+	if !replicated {
+		return false, nil
+	}
+
+	removed := false
+	for _, removeMasters := range []bool{false, true} {
+		for i := len(ips) - 1; i >= size; i-- {
+			node := t.NodeByIP(ips[i])
+			if node == nil || node.IsMaster() != removeMasters {
+				continue
+			}
+			if err := a.DelNode(ips, ips[i], node.ID); err != nil {
+				return false, err
+			}
+			removed = true
+		}
+	}
+	return !removed, nil
+}
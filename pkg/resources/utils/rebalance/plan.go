@@ -0,0 +1,282 @@
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+)
+
+//计划里的一个master，Name在job里是节点的ip，在operator里是pod的名字
+type Shard struct {
+	Name string
+	//当前持有的slot，还没有加入集群的新master为空
+	Slots []topology.SlotRange
+	//目标分布里的权重，0表示把slot全部迁走
+	Weight int
+}
+
+//一次迁移：把Slots从Source迁到Target
+type Move struct {
+	Source string               `json:"source"`
+	Target string               `json:"target"`
+	Slots  []topology.SlotRange `json:"slots"`
+}
+
+func (m Move) SlotCount() int {
+	count := 0
+	for _, r := range m.Slots {
+		count += r.Count()
+	}
+	return count
+}
+
+//展开成单个slot的列表
+func (m Move) SlotList() []int {
+	slots := make([]int, 0, m.SlotCount())
+	for _, r := range m.Slots {
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+//执行之前可以先检查的迁移计划
+type Plan struct {
+	Moves []Move `json:"moves,omitempty"`
+	//执行完之后每个master持有的slot个数
+	Distribution map[string]int `json:"distribution"`
+}
+
+//所有迁移的slot个数
+func (p *Plan) SlotCount() int {
+	count := 0
+	for _, m := range p.Moves {
+		count += m.SlotCount()
+	}
+	return count
+}
+
+//每行一个迁移，最后是执行之后的分布
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, m := range p.Moves {
+		ranges := make([]string, 0, len(m.Slots))
+		for _, r := range m.Slots {
+			ranges = append(ranges, r.String())
+		}
+		fmt.Fprintf(&b, "move %d slots from %s to %s: %s\n", m.SlotCount(), m.Source, m.Target, strings.Join(ranges, " "))
+	}
+	names := make([]string, 0, len(p.Distribution))
+	for name := range p.Distribution {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %d slots\n", name, p.Distribution[name])
+	}
+	return b.String()
+}
+
+//计算从当前分布到按权重分布需要的最少的slot迁移
+//每个master的目标个数是按权重计算的份额取整，取整剩下的slot优先留给当前已经持有更多slot的master，这样迁移的slot个数最少
+//多出来的slot从每个master的末尾开始迁出，迁移之后剩下的slot尽量保持连续
+func Compute(shards []Shard) (*Plan, error) {
+	if err := validate(shards); err != nil {
+		return nil, err
+	}
+
+	totalWeight := 0
+	for _, s := range shards {
+		totalWeight += s.Weight
+	}
+	current := make([]int, len(shards))
+	expected := make([]int, len(shards))
+	assigned := 0
+	for i, s := range shards {
+		current[i] = countSlots(s.Slots)
+		expected[i] = topology.TotalSlots * s.Weight / totalWeight
+		assigned += expected[i]
+	}
+	//取整剩下的slot，每个master最多多分一个
+	var candidates []int
+	for i, s := range shards {
+		if s.Weight > 0 {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		i, j := candidates[a], candidates[b]
+		if current[i]-expected[i] != current[j]-expected[j] {
+			return current[i]-expected[i] > current[j]-expected[j]
+		}
+		return shards[i].Name < shards[j].Name
+	})
+	for _, i := range candidates[:topology.TotalSlots-assigned] {
+		expected[i]++
+	}
+
+	plan := &Plan{Distribution: map[string]int{}}
+	type surplus struct {
+		index int
+		slots []int
+	}
+	var sources []surplus
+	var targets []int
+	for i, s := range shards {
+		plan.Distribution[s.Name] = expected[i]
+		switch {
+		case current[i] > expected[i]:
+			slots := slotList(s.Slots)
+			sources = append(sources, surplus{index: i, slots: slots[expected[i]:]})
+		case current[i] < expected[i]:
+			targets = append(targets, i)
+		}
+	}
+	//先清空权重为0的master，其余按多出来的个数从多到少
+	sort.SliceStable(sources, func(a, b int) bool {
+		i, j := sources[a].index, sources[b].index
+		if (shards[i].Weight == 0) != (shards[j].Weight == 0) {
+			return shards[i].Weight == 0
+		}
+		if len(sources[a].slots) != len(sources[b].slots) {
+			return len(sources[a].slots) > len(sources[b].slots)
+		}
+		return shards[i].Name < shards[j].Name
+	})
+	sort.SliceStable(targets, func(a, b int) bool {
+		i, j := targets[a], targets[b]
+		if expected[i]-current[i] != expected[j]-current[j] {
+			return expected[i]-current[i] > expected[j]-current[j]
+		}
+		return shards[i].Name < shards[j].Name
+	})
+
+	t := 0
+	for _, source := range sources {
+		slots := source.slots
+		for len(slots) > 0 {
+			i := targets[t]
+			n := expected[i] - current[i]
+			if n > len(slots) {
+				n = len(slots)
+			}
+			plan.Moves = append(plan.Moves, Move{
+				Source: shards[source.index].Name,
+				Target: shards[i].Name,
+				Slots:  ranges(slots[:n]),
+			})
+			slots = slots[n:]
+			current[i] += n
+			if current[i] == expected[i] {
+				t++
+			}
+		}
+	}
+	return plan, nil
+}
+
+//所有的slot都必须分配并且只分配给一个master，至少有一个master的权重大于0
+func validate(shards []Shard) error {
+	names := map[string]bool{}
+	owner := make([]string, topology.TotalSlots)
+	totalWeight := 0
+	assigned := 0
+	for _, s := range shards {
+		if len(s.Name) == 0 {
+			return errors.New("rebalance: shard without a name")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("rebalance: duplicate shard %s", s.Name)
+		}
+		names[s.Name] = true
+		if s.Weight < 0 {
+			return fmt.Errorf("rebalance: negative weight %d for %s", s.Weight, s.Name)
+		}
+		totalWeight += s.Weight
+		for _, slot := range slotList(s.Slots) {
+			if slot < 0 || slot >= topology.TotalSlots {
+				return fmt.Errorf("rebalance: slot %d of %s out of range", slot, s.Name)
+			}
+			if len(owner[slot]) > 0 {
+				return fmt.Errorf("rebalance: slot %d is owned by both %s and %s", slot, owner[slot], s.Name)
+			}
+			owner[slot] = s.Name
+			assigned++
+		}
+	}
+	if totalWeight == 0 {
+		return errors.New("rebalance: no shard with a positive weight")
+	}
+	if assigned != topology.TotalSlots {
+		return fmt.Errorf("rebalance: only %d of %d slots are assigned", assigned, topology.TotalSlots)
+	}
+	return nil
+}
+
+//拓扑里的master按ip转成Shard，targetIPs是执行之后持有slot的master，权重相同
+//targetIPs里的节点必须是可用的master，其余持有slot的master会被清空
+func EvenByIP(t *topology.Topology, targetIPs []string) ([]Shard, error) {
+	isTarget := map[string]bool{}
+	for _, ip := range targetIPs {
+		node := t.NodeByIP(ip)
+		if node == nil {
+			return nil, fmt.Errorf("rebalance: %s is not a cluster member", ip)
+		}
+		if !node.IsMaster() {
+			return nil, fmt.Errorf("rebalance: %s is not a master", ip)
+		}
+		if !node.IsReady() {
+			return nil, fmt.Errorf("rebalance: master %s is not ready", ip)
+		}
+		isTarget[ip] = true
+	}
+	var shards []Shard
+	for _, master := range t.Masters() {
+		if !isTarget[master.IP] && master.SlotCount() == 0 {
+			continue
+		}
+		shard := Shard{Name: master.IP, Slots: master.Slots}
+		if isTarget[master.IP] {
+			shard.Weight = 1
+		}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
+
+func countSlots(slotRanges []topology.SlotRange) int {
+	count := 0
+	for _, r := range slotRanges {
+		count += r.Count()
+	}
+	return count
+}
+
+//按从小到大展开，CLUSTER NODES里的范围不一定是有序的
+func slotList(slotRanges []topology.SlotRange) []int {
+	slots := make([]int, 0, countSlots(slotRanges))
+	for _, r := range slotRanges {
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+//把有序的slot合并成连续的范围
+func ranges(slots []int) []topology.SlotRange {
+	var result []topology.SlotRange
+	for _, slot := range slots {
+		if n := len(result); n > 0 && result[n-1].End+1 == slot {
+			result[n-1].End = slot
+			continue
+		}
+		result = append(result, topology.SlotRange{Start: slot, End: slot})
+	}
+	return result
+}
@@ -0,0 +1,198 @@
+package rebalance
+
+import (
+	"reflect"
+	"testing"
+
+	"xzbc-redis-cluster/pkg/resources/utils/topology"
+)
+
+//redis-trib创建3个master时的分布
+func threeMasters() []Shard {
+	return []Shard{
+		{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 5460}}, Weight: 1},
+		{Name: "b", Slots: []topology.SlotRange{{Start: 5461, End: 10922}}, Weight: 1},
+		{Name: "c", Slots: []topology.SlotRange{{Start: 10923, End: 16383}}, Weight: 1},
+	}
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards []Shard
+		moved  int
+		want   map[string]int
+	}{
+		{
+			name:   "balanced",
+			shards: threeMasters(),
+			moved:  0,
+			want:   map[string]int{"a": 5461, "b": 5462, "c": 5461},
+		},
+		{
+			name:   "scale up",
+			shards: append(threeMasters(), Shard{Name: "d", Weight: 1}),
+			moved:  4096,
+			want:   map[string]int{"a": 4096, "b": 4096, "c": 4096, "d": 4096},
+		},
+		{
+			name: "scale down",
+			shards: func() []Shard {
+				shards := threeMasters()
+				shards[2].Weight = 0
+				return shards
+			}(),
+			moved: 5461,
+			want:  map[string]int{"a": 8192, "b": 8192, "c": 0},
+		},
+		{
+			name: "weighted",
+			shards: func() []Shard {
+				shards := threeMasters()
+				shards[0].Weight = 2
+				return shards
+			}(),
+			moved: 2731,
+			want:  map[string]int{"a": 8192, "b": 4096, "c": 4096},
+		},
+		{
+			//16384/3取整之后剩下1个，留给已经多持有slot的master，不产生额外的迁移
+			name: "remainder",
+			shards: []Shard{
+				{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 5459}}, Weight: 1},
+				{Name: "b", Slots: []topology.SlotRange{{Start: 5460, End: 10919}}, Weight: 1},
+				{Name: "c", Slots: []topology.SlotRange{{Start: 10920, End: 16383}}, Weight: 1},
+			},
+			moved: 2,
+			want:  map[string]int{"a": 5461, "b": 5461, "c": 5462},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := Compute(test.shards)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan.Distribution, test.want) {
+				t.Errorf("Distribution = %v, want %v", plan.Distribution, test.want)
+			}
+			if plan.SlotCount() != test.moved {
+				t.Errorf("%d slots moved, want %d", plan.SlotCount(), test.moved)
+			}
+			checkPlan(t, test.shards, plan)
+		})
+	}
+}
+
+//CLUSTER NODES里的范围不一定有序，迁出的应该是编号最大的slot
+func TestComputeUnsortedRanges(t *testing.T) {
+	shards := []Shard{
+		{Name: "a", Slots: []topology.SlotRange{{Start: 5000, End: 9999}, {Start: 0, End: 4999}}, Weight: 1},
+		{Name: "b", Slots: []topology.SlotRange{{Start: 10000, End: 16383}}, Weight: 1},
+	}
+	plan, err := Compute(shards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Move{{Source: "a", Target: "b", Slots: []topology.SlotRange{{Start: 8192, End: 9999}}}}
+	if !reflect.DeepEqual(plan.Moves, want) {
+		t.Errorf("Moves = %v, want %v", plan.Moves, want)
+	}
+	checkPlan(t, shards, plan)
+}
+
+//权重为0的master先清空，即使其他master多出来的slot更多
+func TestComputeDrainsZeroWeightFirst(t *testing.T) {
+	shards := []Shard{
+		{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 14335}}, Weight: 1},
+		{Name: "b", Slots: []topology.SlotRange{{Start: 14336, End: 16383}}, Weight: 0},
+		{Name: "c", Weight: 1},
+	}
+	plan, err := Compute(shards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Moves) == 0 || plan.Moves[0].Source != "b" {
+		t.Fatalf("the first move should drain b, got %v", plan.Moves)
+	}
+	if plan.Distribution["b"] != 0 {
+		t.Errorf("b keeps %d slots, want 0", plan.Distribution["b"])
+	}
+	checkPlan(t, shards, plan)
+}
+
+func TestComputeInvalid(t *testing.T) {
+	all := []topology.SlotRange{{Start: 0, End: 16383}}
+	tests := []struct {
+		name   string
+		shards []Shard
+	}{
+		{"no shards", nil},
+		{"empty name", []Shard{{Slots: all, Weight: 1}}},
+		{"duplicate name", []Shard{
+			{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 8191}}, Weight: 1},
+			{Name: "a", Slots: []topology.SlotRange{{Start: 8192, End: 16383}}, Weight: 1},
+		}},
+		{"negative weight", []Shard{{Name: "a", Slots: all, Weight: -1}}},
+		{"slot out of range", []Shard{{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 16384}}, Weight: 1}}},
+		{"slot owned twice", []Shard{
+			{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 8192}}, Weight: 1},
+			{Name: "b", Slots: []topology.SlotRange{{Start: 8192, End: 16383}}, Weight: 1},
+		}},
+		{"no positive weight", []Shard{{Name: "a", Slots: all}}},
+		{"unassigned slots", []Shard{{Name: "a", Slots: []topology.SlotRange{{Start: 0, End: 100}}, Weight: 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Compute(test.shards); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+//按顺序执行所有迁移，检查：
+//每次迁移的slot都属于Source，迁移之后每个master的slot个数等于Distribution
+//迁移的slot总数等于每个master多出来的slot个数之和，也就是最少的迁移
+func checkPlan(t *testing.T, shards []Shard, plan *Plan) {
+	t.Helper()
+	owner := map[int]string{}
+	surplus := 0
+	for _, s := range shards {
+		for _, slot := range slotList(s.Slots) {
+			owner[slot] = s.Name
+		}
+		if n := countSlots(s.Slots) - plan.Distribution[s.Name]; n > 0 {
+			surplus += n
+		}
+	}
+	if plan.SlotCount() != surplus {
+		t.Errorf("%d slots moved, want %d", plan.SlotCount(), surplus)
+	}
+	for _, m := range plan.Moves {
+		if m.Source == m.Target {
+			t.Errorf("move from %s to itself", m.Source)
+		}
+		for _, slot := range m.SlotList() {
+			if owner[slot] != m.Source {
+				t.Fatalf("slot %d is moved from %s but owned by %s", slot, m.Source, owner[slot])
+			}
+			owner[slot] = m.Target
+		}
+	}
+	counts := map[string]int{}
+	for _, name := range owner {
+		counts[name]++
+	}
+	total := 0
+	for name, want := range plan.Distribution {
+		total += want
+		if counts[name] != want {
+			t.Errorf("%s has %d slots after the moves, want %d", name, counts[name], want)
+		}
+	}
+	if total != topology.TotalSlots {
+		t.Errorf("Distribution covers %d slots, want %d", total, topology.TotalSlots)
+	}
+}
@@ -0,0 +1,221 @@
+package redisclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+//redis返回的错误信息，例如：-ERR unknown command
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+//一个最简单的redis连接，直接使用RESP协议和redis节点通信
+//不是并发安全的，每个goroutine需要单独建立连接
+type Client struct {
+	addr    string
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+//建立连接时的参数
+type Options struct {
+	//开启了requirepass的节点，连接之后先执行AUTH
+	Password string
+	//开启了TLS的节点，使用这个配置建立TLS连接
+	TLSConfig *tls.Config
+}
+
+//连接一个redis节点，addr的格式：ip:6379
+func Dial(addr string) (*Client, error) {
+	return DialWithOptions(addr, Options{})
+}
+
+func DialWithOptions(addr string, options Options) (*Client, error) {
+	var conn net.Conn
+	var err error
+	if options.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: defaultTimeout}, "tcp", addr, options.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, defaultTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		addr:    addr,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: defaultTimeout,
+	}
+
+	if len(options.Password) > 0 {
+		if _, err := c.Do("AUTH", options.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+//发送一条命令并读取返回结果
+//返回值的类型：string(简单字符串和批量字符串)，int64，[]interface{}，nil
+//redis返回的错误会以Error类型返回
+func (c *Client) Do(args ...string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errors.New("redisclient: empty command")
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	var buf strings.Builder
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := io.WriteString(c.conn, buf.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+//执行命令，并要求返回结果是字符串
+func (c *Client) String(args ...string) (string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redisclient: unexpected reply type %T for %s", reply, args[0])
+	}
+	return s, nil
+}
+
+//CLUSTER NODES的原始输出
+func (c *Client) ClusterNodes() (string, error) {
+	return c.String("CLUSTER", "NODES")
+}
+
+//CLUSTER INFO的输出，解析成key-value的形式，例如cluster_state:ok
+func (c *Client) ClusterInfo() (map[string]string, error) {
+	s, err := c.String("CLUSTER", "INFO")
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(s), nil
+}
+
+//INFO的输出，section为空时返回默认的全部分组
+func (c *Client) Info(section ...string) (map[string]string, error) {
+	s, err := c.String(append([]string{"INFO"}, section...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(s), nil
+}
+
+//CONFIG SET，修改运行中节点的配置
+func (c *Client) ConfigSet(name, value string) error {
+	_, err := c.Do("CONFIG", "SET", name, value)
+	return err
+}
+
+//解析INFO/CLUSTER INFO格式的输出，忽略以#开头的分组行
+func parseInfo(s string) map[string]string {
+	result := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result
+}
+
+func (c *Client) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redisclient: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			item, err := c.readReply()
+			//数组中单个元素的错误不影响读取其他元素
+			if e, ok := err.(Error); ok {
+				items[i] = e
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redisclient: unexpected reply %q", line)
+}
+
+func (c *Client) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,173 @@
+package redisclient
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//集群管理用到的命令，返回+OK的命令只返回error
+//redis的错误会以Error类型返回，调用方可以区分网络错误和redis拒绝执行的命令
+
+//修改单个命令的超时时间，MIGRATE迁移大key时需要比默认的5秒更长的时间
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+//执行命令，并要求返回+OK
+func (c *Client) ok(args ...string) error {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return err
+	}
+	if s, ok := reply.(string); !ok || s != "OK" {
+		return fmt.Errorf("redisclient: unexpected reply %v for %s", reply, args[0])
+	}
+	return nil
+}
+
+//CLUSTER MEET，让当前节点和ip:port握手，加入同一个集群
+func (c *Client) ClusterMeet(ip string, port int) error {
+	return c.ok("CLUSTER", "MEET", ip, strconv.Itoa(port))
+}
+
+//CLUSTER ADDSLOTS，把slot分配给当前节点
+func (c *Client) ClusterAddSlots(slots ...int) error {
+	if len(slots) == 0 {
+		return nil
+	}
+	args := []string{"CLUSTER", "ADDSLOTS"}
+	for _, slot := range slots {
+		args = append(args, strconv.Itoa(slot))
+	}
+	return c.ok(args...)
+}
+
+//CLUSTER SET-CONFIG-EPOCH，只能在新建的空节点上执行，创建集群时给每个master设置不同的epoch
+func (c *Client) ClusterSetConfigEpoch(epoch int64) error {
+	return c.ok("CLUSTER", "SET-CONFIG-EPOCH", strconv.FormatInt(epoch, 10))
+}
+
+//CLUSTER SETSLOT <slot> IMPORTING <source-node-id>，在接收slot的节点上执行
+func (c *Client) ClusterSetSlotImporting(slot int, sourceID string) error {
+	return c.ok("CLUSTER", "SETSLOT", strconv.Itoa(slot), "IMPORTING", sourceID)
+}
+
+//CLUSTER SETSLOT <slot> MIGRATING <destination-node-id>，在迁出slot的节点上执行
+func (c *Client) ClusterSetSlotMigrating(slot int, destinationID string) error {
+	return c.ok("CLUSTER", "SETSLOT", strconv.Itoa(slot), "MIGRATING", destinationID)
+}
+
+//CLUSTER SETSLOT <slot> NODE <node-id>，迁移完成之后把slot分配给新的节点
+func (c *Client) ClusterSetSlotNode(slot int, nodeID string) error {
+	return c.ok("CLUSTER", "SETSLOT", strconv.Itoa(slot), "NODE", nodeID)
+}
+
+//CLUSTER SETSLOT <slot> STABLE，清除importing和migrating状态
+func (c *Client) ClusterSetSlotStable(slot int) error {
+	return c.ok("CLUSTER", "SETSLOT", strconv.Itoa(slot), "STABLE")
+}
+
+//CLUSTER REPLICATE，把当前节点设置为nodeID的slave
+func (c *Client) ClusterReplicate(nodeID string) error {
+	return c.ok("CLUSTER", "REPLICATE", nodeID)
+}
+
+//CLUSTER FORGET，把nodeID从当前节点的节点表里删除，60秒之内不会通过gossip重新加回来
+func (c *Client) ClusterForget(nodeID string) error {
+	return c.ok("CLUSTER", "FORGET", nodeID)
+}
+
+//CLUSTER FAILOVER，在slave上执行，option可以是空、FORCE或者TAKEOVER
+func (c *Client) ClusterFailover(option string) error {
+	if len(option) == 0 {
+		return c.ok("CLUSTER", "FAILOVER")
+	}
+	return c.ok("CLUSTER", "FAILOVER", option)
+}
+
+//CLUSTER RESET SOFT，节点忘记所有其他节点，从集群中移除之后执行
+//master上还有key时redis会拒绝执行
+func (c *Client) ClusterResetSoft() error {
+	return c.ok("CLUSTER", "RESET", "SOFT")
+}
+
+//CLUSTER COUNTKEYSINSLOT
+func (c *Client) ClusterCountKeysInSlot(slot int) (int64, error) {
+	reply, err := c.Do("CLUSTER", "COUNTKEYSINSLOT", strconv.Itoa(slot))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redisclient: unexpected reply type %T for CLUSTER COUNTKEYSINSLOT", reply)
+	}
+	return n, nil
+}
+
+//CLUSTER GETKEYSINSLOT，最多返回count个key
+func (c *Client) ClusterGetKeysInSlot(slot, count int) ([]string, error) {
+	reply, err := c.Do("CLUSTER", "GETKEYSINSLOT", strconv.Itoa(slot), strconv.Itoa(count))
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redisclient: unexpected reply type %T for CLUSTER GETKEYSINSLOT", reply)
+	}
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		key, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("redisclient: unexpected key type %T for CLUSTER GETKEYSINSLOT", item)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+//MIGRATE的参数
+type MigrateOptions struct {
+	//目标节点开启了requirepass时使用，需要redis 4.0.7及以上
+	Password string
+	//目标节点上已经有同名的key时覆盖它
+	Replace bool
+	//单个MIGRATE的超时时间
+	Timeout time.Duration
+}
+
+//MIGRATE host port "" 0 timeout [REPLACE] [AUTH password] KEYS key...，一次迁移多个key，需要redis 3.0.6及以上
+//集群模式只有db 0
+func (c *Client) Migrate(ip string, port int, keys []string, options MigrateOptions) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	args := []string{"MIGRATE", ip, strconv.Itoa(port), "", "0", strconv.FormatInt(int64(timeout/time.Millisecond), 10)}
+	if options.Replace {
+		args = append(args, "REPLACE")
+	}
+	if len(options.Password) > 0 {
+		args = append(args, "AUTH", options.Password)
+	}
+	args = append(args, "KEYS")
+	args = append(args, keys...)
+
+	//MIGRATE是同步执行的，读取结果的时间要比redis内部的超时长一些
+	saved := c.timeout
+	c.timeout = timeout + defaultTimeout
+	defer func() { c.timeout = saved }()
+
+	reply, err := c.Do(args...)
+	if err != nil {
+		return err
+	}
+	//key在源节点上已经不存在时返回NOKEY，不是错误
+	if s, ok := reply.(string); !ok || (s != "OK" && s != "NOKEY") {
+		return fmt.Errorf("redisclient: unexpected reply %v for MIGRATE", reply)
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package topology
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//redis cluster的slot总数
+const TotalSlots = 16384
+
+//CLUSTER NODES里的flag
+const (
+	FlagMyself     = "myself"
+	FlagMaster     = "master"
+	FlagSlave      = "slave"
+	FlagPFail      = "fail?"
+	FlagFail       = "fail"
+	FlagHandshake  = "handshake"
+	FlagNoAddr     = "noaddr"
+	FlagNoFailover = "nofailover"
+)
+
+//节点之间集群总线的连接状态
+const (
+	LinkConnected    = "connected"
+	LinkDisconnected = "disconnected"
+)
+
+//连续的一段slot，只有一个slot时Start和End相同
+type SlotRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (r SlotRange) Count() int {
+	return r.End - r.Start + 1
+}
+
+//和CLUSTER NODES的格式一致：0-5460或者5461
+func (r SlotRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+//CLUSTER NODES里的一行
+type Node struct {
+	ID string
+	IP string
+	//redis的端口和集群总线的端口，redis 4之前的输出里没有集群总线端口，为0
+	Port        int
+	ClusterPort int
+	//redis 7开始可以通过cluster-announce-hostname设置
+	Hostname string
+	Flags    []string
+	//slave对应的master的id，master为空
+	MasterID    string
+	PingSent    int64
+	PongRecv    int64
+	ConfigEpoch int64
+	LinkState   string
+	Slots       []SlotRange
+	//正在迁出的slot，value是接收slot的节点id，只出现在被连接的节点自己的那一行
+	Migrating map[int]string
+	//正在迁入的slot，value是迁出slot的节点id
+	Importing map[int]string
+}
+
+func (n *Node) HasFlag(flag string) bool {
+	for _, f := range n.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) IsMaster() bool {
+	return n.HasFlag(FlagMaster)
+}
+
+func (n *Node) IsReplica() bool {
+	return n.HasFlag(FlagSlave)
+}
+
+func (n *Node) IsMyself() bool {
+	return n.HasFlag(FlagMyself)
+}
+
+//被集群标记为fail，或者被连接的节点认为它可能已经失败
+func (n *Node) IsFailing() bool {
+	return n.HasFlag(FlagFail) || n.HasFlag(FlagPFail)
+}
+
+//握手还没有完成或者地址未知的节点不能参与迁移
+func (n *Node) IsReady() bool {
+	return !n.HasFlag(FlagHandshake) && !n.HasFlag(FlagNoAddr) && !n.IsFailing() &&
+		(n.IsMyself() || n.LinkState == LinkConnected)
+}
+
+func (n *Node) SlotCount() int {
+	count := 0
+	for _, r := range n.Slots {
+		count += r.Count()
+	}
+	return count
+}
+
+func (n *Node) OwnsSlot(slot int) bool {
+	for _, r := range n.Slots {
+		if slot >= r.Start && slot <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+//展开成单个slot的列表，按从小到大排列
+func (n *Node) SlotList() []int {
+	slots := make([]int, 0, n.SlotCount())
+	for _, r := range n.Slots {
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+//从一个节点看到的整个集群
+type Topology struct {
+	Nodes []*Node
+}
+
+//解析CLUSTER NODES的输出，支持redis 3到7的格式
+//每一行：<id> <ip:port[@cport][,hostname[,aux=value]...]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func Parse(output string) (*Topology, error) {
+	t := &Topology{}
+	for i, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		node, err := parseNode(line)
+		if err != nil {
+			return nil, fmt.Errorf("CLUSTER NODES line %d: %v", i+1, err)
+		}
+		t.Nodes = append(t.Nodes, node)
+	}
+	return t, nil
+}
+
+func parseNode(line string) (*Node, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("expected at least 8 fields, got %d", len(fields))
+	}
+	node := &Node{
+		ID:        fields[0],
+		Flags:     strings.Split(fields[2], ","),
+		LinkState: fields[7],
+	}
+	if err := node.parseAddress(fields[1]); err != nil {
+		return nil, err
+	}
+	if fields[3] != "-" {
+		node.MasterID = fields[3]
+	}
+	var err error
+	if node.PingSent, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid ping-sent %q", fields[4])
+	}
+	if node.PongRecv, err = strconv.ParseInt(fields[5], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid pong-recv %q", fields[5])
+	}
+	if node.ConfigEpoch, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid config-epoch %q", fields[6])
+	}
+	for _, slot := range fields[8:] {
+		if err := node.parseSlot(slot); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+//地址的几种格式：
+//redis 3: 10.0.0.1:6379
+//redis 4-6: 10.0.0.1:6379@16379
+//redis 7: 10.0.0.1:6379@16379,hostname 或者 10.0.0.1:6379@16379,,shard-id=...
+//没有地址的节点：:0@0，刚启动还没有和其他节点通信时myself的ip为空：:6379@16379
+func (n *Node) parseAddress(address string) error {
+	parts := strings.Split(address, ",")
+	if len(parts) > 1 && !strings.Contains(parts[1], "=") {
+		n.Hostname = parts[1]
+	}
+	addr := parts[0]
+	if i := strings.Index(addr, "@"); i != -1 {
+		port, err := strconv.Atoi(addr[i+1:])
+		if err != nil {
+			return fmt.Errorf("invalid cluster bus port in %q", address)
+		}
+		n.ClusterPort = port
+		addr = addr[:i]
+	}
+	i := strings.LastIndex(addr, ":")
+	if i == -1 {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	port, err := strconv.Atoi(addr[i+1:])
+	if err != nil {
+		return fmt.Errorf("invalid port in %q", address)
+	}
+	n.IP = strings.TrimSuffix(strings.TrimPrefix(addr[:i], "["), "]")
+	n.Port = port
+	return nil
+}
+
+//slot的几种格式：5461、0-5460、[5461->-<node-id>]正在迁出、[5461-<-<node-id>]正在迁入
+func (n *Node) parseSlot(slot string) error {
+	if strings.HasPrefix(slot, "[") && strings.HasSuffix(slot, "]") {
+		inner := slot[1 : len(slot)-1]
+		if i := strings.Index(inner, "->-"); i != -1 {
+			s, err := strconv.Atoi(inner[:i])
+			if err != nil {
+				return fmt.Errorf("invalid migrating slot %q", slot)
+			}
+			if n.Migrating == nil {
+				n.Migrating = map[int]string{}
+			}
+			n.Migrating[s] = inner[i+3:]
+			return nil
+		}
+		if i := strings.Index(inner, "-<-"); i != -1 {
+			s, err := strconv.Atoi(inner[:i])
+			if err != nil {
+				return fmt.Errorf("invalid importing slot %q", slot)
+			}
+			if n.Importing == nil {
+				n.Importing = map[int]string{}
+			}
+			n.Importing[s] = inner[i+3:]
+			return nil
+		}
+		return fmt.Errorf("invalid slot %q", slot)
+	}
+
+	bounds := strings.SplitN(slot, "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return fmt.Errorf("invalid slot %q", slot)
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = strconv.Atoi(bounds[1]); err != nil {
+			return fmt.Errorf("invalid slot %q", slot)
+		}
+	}
+	if start < 0 || end >= TotalSlots || end < start {
+		return fmt.Errorf("slot %q out of range", slot)
+	}
+	n.Slots = append(n.Slots, SlotRange{Start: start, End: end})
+	return nil
+}
+
+//被连接的节点自己
+func (t *Topology) Myself() *Node {
+	for _, n := range t.Nodes {
+		if n.IsMyself() {
+			return n
+		}
+	}
+	return nil
+}
+
+func (t *Topology) Node(id string) *Node {
+	for _, n := range t.Nodes {
+		if n.ID == id {
+			return n
+		}
+	}
+	return nil
+}
+
+func (t *Topology) NodeByIP(ip string) *Node {
+	for _, n := range t.Nodes {
+		if n.IP == ip {
+			return n
+		}
+	}
+	return nil
+}
+
+//所有的master，按第一个slot排序，没有slot的排在最后
+func (t *Topology) Masters() []*Node {
+	var masters []*Node
+	for _, n := range t.Nodes {
+		if n.IsMaster() {
+			masters = append(masters, n)
+		}
+	}
+	sort.SliceStable(masters, func(i, j int) bool {
+		return firstSlot(masters[i]) < firstSlot(masters[j])
+	})
+	return masters
+}
+
+func firstSlot(n *Node) int {
+	if len(n.Slots) == 0 {
+		return TotalSlots
+	}
+	return n.Slots[0].Start
+}
+
+//master的所有slave
+func (t *Topology) Replicas(masterID string) []*Node {
+	var replicas []*Node
+	for _, n := range t.Nodes {
+		if n.IsReplica() && n.MasterID == masterID {
+			replicas = append(replicas, n)
+		}
+	}
+	return replicas
+}
+
+//slave对应的master，master或者master不在节点表里时返回nil
+func (t *Topology) MasterOf(n *Node) *Node {
+	if len(n.MasterID) == 0 {
+		return nil
+	}
+	return t.Node(n.MasterID)
+}
+
+//已经分配给master的slot个数
+func (t *Topology) AssignedSlots() int {
+	count := 0
+	for _, n := range t.Masters() {
+		count += n.SlotCount()
+	}
+	return count
+}
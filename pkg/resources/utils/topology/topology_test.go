@@ -0,0 +1,227 @@
+package topology
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	myselfID   = "2c1a1e1b4a8d0c3c8ea25c2b8bbd1dc1d5cbb7e2"
+	importerID = "9c8b7a6f5e4d3c2b1a0f9e8d7c6b5a4f3e2d1c0b"
+)
+
+//testdata/README.md里列出的每个文件的预期结果
+func TestParseCorpus(t *testing.T) {
+	tests := []struct {
+		file string
+		//期望解析失败
+		invalid  bool
+		nodes    int
+		masters  int
+		assigned int
+		check    func(t *testing.T, topo *Topology)
+	}{
+		{
+			file:     "redis-3.0-create.txt",
+			nodes:    6,
+			masters:  3,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				for _, n := range topo.Nodes {
+					if n.ClusterPort != 0 {
+						t.Errorf("node %s: ClusterPort = %d, want 0", n.ID, n.ClusterPort)
+					}
+				}
+				for _, master := range topo.Masters() {
+					if replicas := topo.Replicas(master.ID); len(replicas) != 1 {
+						t.Errorf("master %s has %d replicas, want 1", master.ID, len(replicas))
+					}
+				}
+			},
+		},
+		{
+			file:     "redis-3.2-fresh-node.txt",
+			nodes:    1,
+			masters:  1,
+			assigned: 0,
+			check: func(t *testing.T, topo *Topology) {
+				myself := mustMyself(t, topo)
+				if myself.IP != "" || myself.Port != 6379 {
+					t.Errorf("myself address = %q:%d, want \"\":6379", myself.IP, myself.Port)
+				}
+			},
+		},
+		{
+			file:     "redis-3.2-failover.txt",
+			nodes:    6,
+			masters:  4,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				failed := topo.Node(myselfID)
+				if failed == nil || !failed.IsFailing() || failed.IsReady() {
+					t.Errorf("failed master %s should be failing and not ready", myselfID)
+				}
+				promoted := topo.NodeByIP("10.244.1.13")
+				if promoted == nil || !promoted.IsMaster() || promoted.ConfigEpoch != 7 || !promoted.OwnsSlot(0) {
+					t.Errorf("10.244.1.13 should be the promoted master with epoch 7 owning slot 0")
+				}
+			},
+		},
+		{
+			file:     "redis-4.0-migrating.txt",
+			nodes:    7,
+			masters:  4,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				myself := mustMyself(t, topo)
+				if myself.Migrating[4096] != importerID {
+					t.Errorf("Migrating[4096] = %q, want %q", myself.Migrating[4096], importerID)
+				}
+				if myself.SlotCount() != 5460 {
+					t.Errorf("myself owns %d slots, want 5460", myself.SlotCount())
+				}
+				if myself.OwnsSlot(4096) {
+					t.Errorf("myself should not own slot 4096")
+				}
+			},
+		},
+		{
+			file:     "redis-4.0-importing.txt",
+			nodes:    4,
+			masters:  4,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				myself := mustMyself(t, topo)
+				if myself.Importing[4096] != myselfID {
+					t.Errorf("Importing[4096] = %q, want %q", myself.Importing[4096], myselfID)
+				}
+				if myself.SlotCount() != 0 {
+					t.Errorf("myself owns %d slots, want 0", myself.SlotCount())
+				}
+			},
+		},
+		{
+			file:     "redis-5.0-handshake.txt",
+			nodes:    5,
+			masters:  4,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				for _, id := range []string{
+					"0a1b2c3d4e5f60718293a4b5c6d7e8f901234567",
+					"1b2c3d4e5f60718293a4b5c6d7e8f9012345678a",
+				} {
+					n := topo.Node(id)
+					if n == nil || n.IsReady() {
+						t.Errorf("node %s should not be ready", id)
+					}
+				}
+				if n := topo.Node("1b2c3d4e5f60718293a4b5c6d7e8f9012345678a"); n != nil && n.IP != "" {
+					t.Errorf("noaddr node IP = %q, want \"\"", n.IP)
+				}
+				if !mustMyself(t, topo).IsReady() {
+					t.Errorf("myself should be ready")
+				}
+			},
+		},
+		{
+			file:     "redis-6.2-pfail.txt",
+			nodes:    6,
+			masters:  3,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				pfail := topo.NodeByIP("10.244.2.15")
+				if pfail == nil || !pfail.IsFailing() || pfail.IsReady() {
+					t.Errorf("the fail? master should be failing and not ready")
+				}
+				for _, n := range topo.Nodes {
+					if n.Port != 6380 || n.ClusterPort != 16380 {
+						t.Errorf("node %s: port %d@%d, want 6380@16380", n.ID, n.Port, n.ClusterPort)
+					}
+				}
+			},
+		},
+		{
+			file:     "redis-7.0-hostname.txt",
+			nodes:    6,
+			masters:  3,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				myself := mustMyself(t, topo)
+				if myself.Hostname != "redis-0.redis.default.svc.cluster.local" {
+					t.Errorf("myself Hostname = %q", myself.Hostname)
+				}
+				if myself.SlotCount() != 5461 || !myself.OwnsSlot(1365) {
+					t.Errorf("myself should own 5461 slots including 1365, got %d", myself.SlotCount())
+				}
+				if n := topo.NodeByIP("10.244.2.16"); n == nil || n.Hostname != "" {
+					t.Errorf("10.244.2.16 should have an empty hostname")
+				}
+				if n := topo.NodeByIP("10.244.1.13"); n == nil || !n.IsReplica() || !n.HasFlag(FlagNoFailover) {
+					t.Errorf("10.244.1.13 should be a nofailover replica")
+				}
+			},
+		},
+		{
+			file:     "redis-7.2-aux-fields.txt",
+			nodes:    6,
+			masters:  3,
+			assigned: TotalSlots,
+			check: func(t *testing.T, topo *Topology) {
+				want := map[string]string{
+					"10.244.1.12": "",
+					"10.244.2.15": "redis-2",
+					"10.244.3.9":  "",
+					"10.244.2.16": "redis-3",
+				}
+				for ip, hostname := range want {
+					n := topo.NodeByIP(ip)
+					if n == nil || n.Hostname != hostname {
+						t.Errorf("%s should have hostname %q", ip, hostname)
+					}
+				}
+			},
+		},
+		{file: "invalid-truncated.txt", invalid: true},
+		{file: "invalid-slot.txt", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			topo, err := Parse(string(data))
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(topo.Nodes) != test.nodes {
+				t.Errorf("%d nodes, want %d", len(topo.Nodes), test.nodes)
+			}
+			if masters := len(topo.Masters()); masters != test.masters {
+				t.Errorf("%d masters, want %d", masters, test.masters)
+			}
+			if assigned := topo.AssignedSlots(); assigned != test.assigned {
+				t.Errorf("%d slots assigned, want %d", assigned, test.assigned)
+			}
+			if test.check != nil {
+				test.check(t, topo)
+			}
+		})
+	}
+}
+
+func mustMyself(t *testing.T, topo *Topology) *Node {
+	myself := topo.Myself()
+	if myself == nil {
+		t.Fatalf("no myself node")
+	}
+	return myself
+}
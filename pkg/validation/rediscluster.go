@@ -0,0 +1,265 @@
+package validation
+
+import (
+	"sort"
+	"strings"
+
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/resources/configmap"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//redis cluster至少需要3个master
+const MinShards = 3
+
+//校验一个RedisCluster的spec，创建和更新都会用到
+//在webhook和controller里都会调用，避免不合法的CR让operator崩溃
+func ValidateRedisCluster(redisCluster *v1alpha1.RedisCluster) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := &redisCluster.Spec
+	specPath := field.NewPath("spec")
+
+	allErrs = append(allErrs, validateSizing(spec, specPath)...)
+
+	if len(spec.Image) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("image"), "redis image must be set"))
+	}
+	//只有集群模式需要用job创建集群和扩缩容，由operator执行时不需要job的镜像
+	if spec.IsClusterMode() && spec.GetExecutor() == v1alpha1.ExecutorJob && len(spec.RedisTribImage) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("redistribimage"),
+			"the image used by the create and scale jobs must be set"))
+	}
+
+	//statefulset.New里会对storage做resource.MustParse，不合法的值会导致operator panic
+	if len(spec.Storage) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("storage"), "storage size must be set, e.g. 5Gi"))
+	} else if quantity, err := resource.ParseQuantity(spec.Storage); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("storage"), spec.Storage, err.Error()))
+	} else if quantity.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("storage"), spec.Storage, "must be greater than zero"))
+	}
+
+	allErrs = append(allErrs, validateConfig(spec.Config, specPath.Child("config"))...)
+
+	if spec.Auth != nil && len(spec.Auth.SecretRef.Name) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("auth", "secretRef", "name"),
+			"the secret holding the redis password must be set"))
+	}
+	if spec.TLS != nil && len(spec.TLS.SecretRef.Name) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("tls", "secretRef", "name"),
+			"the secret holding the certificate, key and CA must be set"))
+	}
+
+	allErrs = append(allErrs, validatePodTemplate(spec.PodTemplate, specPath.Child("podTemplate"))...)
+
+	switch spec.GetDeletionPolicy() {
+	case v1alpha1.DeletionPolicyRetain, v1alpha1.DeletionPolicyDelete, v1alpha1.DeletionPolicySnapshot:
+	default:
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("deletionPolicy"), spec.DeletionPolicy,
+			[]string{string(v1alpha1.DeletionPolicyRetain), string(v1alpha1.DeletionPolicyDelete),
+				string(v1alpha1.DeletionPolicySnapshot)}))
+	}
+
+	switch spec.GetExecutor() {
+	case v1alpha1.ExecutorJob, v1alpha1.ExecutorOperator:
+	default:
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("updateStrategy", "executor"),
+			spec.UpdateStrategy.Executor, []string{string(v1alpha1.ExecutorJob), string(v1alpha1.ExecutorOperator)}))
+	}
+
+	if spec.IsClusterMode() {
+		if spec.Sentinel != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("sentinel"),
+				"sentinel is only used when clustermode is false"))
+		}
+	} else {
+		allErrs = append(allErrs, validateSentinel(spec, specPath)...)
+	}
+
+	return allErrs
+}
+
+//校验更新操作，除了ValidateRedisCluster的规则之外，还要检查不能修改的字段
+//原来的spec已经不合法时（webhook部署之前创建的CR，或者不满足新加的规则），只检查不能修改的字段，
+//否则这个CR的spec再也无法修改
+func ValidateRedisClusterUpdate(newCluster, oldCluster *v1alpha1.RedisCluster) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(ValidateRedisCluster(oldCluster)) == 0 {
+		allErrs = ValidateRedisCluster(newCluster)
+	}
+	specPath := field.NewPath("spec")
+	newSpec, oldSpec := &newCluster.Spec, &oldCluster.Spec
+
+	//VolumeClaimTemplates创建之后不能修改，statefulset无法应用这两个字段的变化
+	if newSpec.StorageClassName != oldSpec.StorageClassName {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("storageClassName"),
+			"field is immutable, the StatefulSet volumeClaimTemplates cannot be changed"))
+	}
+	if newSpec.Storage != oldSpec.Storage {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("storage"),
+			"field is immutable, the StatefulSet volumeClaimTemplates cannot be changed"))
+	}
+
+	//修改每个分片的slave个数需要重建集群的拓扑，扩缩容的逻辑不支持
+	//非集群模式只有一个master，可以直接增减slave
+	if newSpec.IsClusterMode() && newSpec.GetReplicasPerShard() != oldSpec.GetReplicasPerShard() {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("replicasPerShard"),
+			"field is immutable, only the number of shards can be changed"))
+	}
+	//开启或关闭TLS会改变节点的端口，nodes.conf里记录的端口会失效
+	if newSpec.TLSEnabled() != oldSpec.TLSEnabled() {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("tls"),
+			"TLS cannot be enabled or disabled on an existing cluster"))
+	}
+	//老版本operator写入的clustermode: false可以改回true，这些集群本来就是redis cluster
+	if newSpec.IsClusterMode() != oldSpec.IsClusterMode() && !oldCluster.HasStaleClusterMode() {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("clustermode"), "field is immutable"))
+	}
+
+	return allErrs
+}
+
+func validateSizing(spec *v1alpha1.RedisClusterSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	replicasPerShard := spec.GetReplicasPerShard()
+	if replicasPerShard < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicasPerShard"), replicasPerShard,
+			"must be greater than or equal to 0"))
+		return allErrs
+	}
+	if spec.ReplicasPerShard != nil && spec.ReplicasPerMaster != nil &&
+		*spec.ReplicasPerShard != *spec.ReplicasPerMaster {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicasPerMaster"), *spec.ReplicasPerMaster,
+			"deprecated field conflicts with replicasPerShard"))
+	}
+
+	//非集群模式只有一个master，pod个数是replicasPerShard+1
+	if !spec.IsClusterMode() {
+		if spec.Shards != nil && *spec.Shards != 1 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("shards"), *spec.Shards,
+				"must be 1 when clustermode is false"))
+		}
+		if spec.Replicas != nil && *spec.Replicas != spec.GetClusterSize() {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), *spec.Replicas,
+				"deprecated field conflicts with replicasPerShard + 1"))
+		}
+		return allErrs
+	}
+
+	switch {
+	case spec.Shards == nil && spec.Replicas == nil:
+		allErrs = append(allErrs, field.Required(specPath.Child("shards"), "the number of shards must be set"))
+		return allErrs
+	case spec.Shards == nil:
+		//只设置了replicas的老的CR，pod个数必须能按replicasPerShard+1分组
+		if *spec.Replicas%(replicasPerShard+1) != 0 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), *spec.Replicas,
+				"must be a multiple of replicasPerShard+1, use shards and replicasPerShard instead"))
+			return allErrs
+		}
+	case spec.Replicas != nil && *spec.Replicas != spec.GetClusterSize():
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), *spec.Replicas,
+			"deprecated field conflicts with shards x (replicasPerShard + 1)"))
+	}
+
+	if shards := spec.GetShards(); shards < MinShards {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("shards"), shards,
+			"a redis cluster needs at least 3 masters"))
+	}
+	return allErrs
+}
+
+//operator用来选择pod的label，不能通过spec.podTemplate设置
+var reservedPodLabels = []string{
+	"crd.xzbc.com.cn/v1alpha1",
+	v1alpha1.RoleLabel,
+	v1alpha1.SentinelLabel,
+}
+
+func validatePodTemplate(podTemplate *v1alpha1.RedisPodTemplate, podTemplatePath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if podTemplate == nil {
+		return allErrs
+	}
+
+	labelsPath := podTemplatePath.Child("labels")
+	allErrs = append(allErrs, metav1validation.ValidateLabels(podTemplate.Labels, labelsPath)...)
+	for _, key := range reservedPodLabels {
+		if _, ok := podTemplate.Labels[key]; ok {
+			allErrs = append(allErrs, field.Forbidden(labelsPath.Key(key), "label is managed by the operator"))
+		}
+	}
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(podTemplate.Annotations,
+		podTemplatePath.Child("annotations"))...)
+	allErrs = append(allErrs, metav1validation.ValidateLabels(podTemplate.NodeSelector,
+		podTemplatePath.Child("nodeSelector"))...)
+	return allErrs
+}
+
+//非集群模式的sentinel配置
+func validateSentinel(spec *v1alpha1.RedisClusterSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	sentinelPath := specPath.Child("sentinel")
+
+	//sentinel和启动脚本还不支持TLS
+	if spec.TLSEnabled() {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("tls"),
+			"TLS is only supported when clustermode is true"))
+	}
+
+	replicas := spec.GetSentinelReplicas()
+	if replicas < 1 {
+		allErrs = append(allErrs, field.Invalid(sentinelPath.Child("replicas"), replicas,
+			"must be greater than 0"))
+		return allErrs
+	}
+	if quorum := spec.GetSentinelQuorum(); quorum < 1 || quorum > replicas {
+		allErrs = append(allErrs, field.Invalid(sentinelPath.Child("quorum"), quorum,
+			"must be between 1 and sentinel.replicas"))
+	}
+	return allErrs
+}
+
+//校验spec.config，operator依赖的配置、集群模式下不安全的配置以及未知的配置都会被拒绝
+func validateConfig(config map[string]string, configPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	//按名字排序，保证错误信息的顺序是固定的
+	var names []string
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := config[name]
+		directive := strings.ToLower(name)
+		path := configPath.Key(name)
+
+		if reason, ok := configmap.UnsafeDirectives[directive]; ok {
+			allErrs = append(allErrs, field.Forbidden(path, "directive is not allowed: "+reason))
+			continue
+		}
+		if configmap.ProtectedDirectives[directive] {
+			allErrs = append(allErrs, field.Forbidden(path, "directive is managed by the operator"))
+			continue
+		}
+		if !configmap.SupportedDirectives[directive] {
+			allErrs = append(allErrs, field.Invalid(path, name, "unknown redis directive"))
+			continue
+		}
+		if len(strings.TrimSpace(value)) == 0 {
+			allErrs = append(allErrs, field.Required(path, "directive value must not be empty"))
+			continue
+		}
+		if strings.Contains(value, "\n") && !configmap.MultiValueDirectives[directive] {
+			allErrs = append(allErrs, field.Invalid(path, value, "directive only accepts a single value"))
+		}
+	}
+	return allErrs
+}
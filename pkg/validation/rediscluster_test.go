@@ -0,0 +1,319 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
+
+//3个分片、每个分片1个slave的合法的集群
+func validCluster() *v1alpha1.RedisCluster {
+	return &v1alpha1.RedisCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default"},
+		Spec: v1alpha1.RedisClusterSpec{
+			Shards:         int32Ptr(3),
+			Image:          "redis:5.0",
+			RedisTribImage: "redistrib:latest",
+			Storage:        "1Gi",
+		},
+	}
+}
+
+//sentinel模式的合法的集群
+func validSentinelCluster() *v1alpha1.RedisCluster {
+	redisCluster := validCluster()
+	redisCluster.Spec.ClusterMode = boolPtr(false)
+	redisCluster.Spec.Shards = nil
+	redisCluster.Spec.ReplicasPerShard = int32Ptr(2)
+	return redisCluster
+}
+
+//errs里有field为path的错误，path为空时errs必须为空
+func checkErrors(t *testing.T, errs field.ErrorList, path string) {
+	t.Helper()
+	if len(path) == 0 {
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors: %v", errs.ToAggregate())
+		}
+		return
+	}
+	for _, err := range errs {
+		if err.Field == path {
+			return
+		}
+	}
+	t.Errorf("expected an error on %s, got %v", path, errs.ToAggregate())
+}
+
+func TestValidateRedisCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster func() *v1alpha1.RedisCluster
+		//为空表示合法
+		field string
+	}{
+		{name: "valid", cluster: validCluster},
+		{name: "legacy replicas", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = nil
+			c.Spec.Replicas = int32Ptr(6)
+			return c
+		}},
+		{name: "legacy replicas with replicasPerMaster", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = nil
+			c.Spec.Replicas = int32Ptr(9)
+			c.Spec.ReplicasPerMaster = int32Ptr(2)
+			return c
+		}},
+		{name: "replicas matching shards", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Replicas = int32Ptr(6)
+			return c
+		}},
+		{name: "operator executor without redistribimage", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.RedisTribImage = ""
+			c.Spec.UpdateStrategy = &v1alpha1.RedisClusterUpdateStrategy{Executor: v1alpha1.ExecutorOperator}
+			return c
+		}},
+		{name: "supported config", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Config = map[string]string{"maxmemory": "1gb", "save": "900 1\n300 10"}
+			return c
+		}},
+		{name: "sentinel", cluster: validSentinelCluster},
+		{name: "tls", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.TLS = &v1alpha1.RedisClusterTLS{SecretRef: corev1.LocalObjectReference{Name: "redis-tls"}}
+			return c
+		}},
+
+		{name: "less than 3 shards", field: "spec.shards", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = int32Ptr(2)
+			return c
+		}},
+		{name: "no size", field: "spec.shards", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = nil
+			return c
+		}},
+		{name: "legacy replicas not divisible", field: "spec.replicas", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = nil
+			c.Spec.Replicas = int32Ptr(7)
+			return c
+		}},
+		{name: "legacy replicas with less than 3 shards", field: "spec.shards", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = nil
+			c.Spec.Replicas = int32Ptr(4)
+			return c
+		}},
+		{name: "replicas conflicting with shards", field: "spec.replicas", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Replicas = int32Ptr(8)
+			return c
+		}},
+		{name: "negative replicasPerShard", field: "spec.replicasPerShard", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.ReplicasPerShard = int32Ptr(-1)
+			return c
+		}},
+		{name: "replicasPerMaster conflicting", field: "spec.replicasPerMaster", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.ReplicasPerShard = int32Ptr(1)
+			c.Spec.ReplicasPerMaster = int32Ptr(2)
+			return c
+		}},
+		{name: "no image", field: "spec.image", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Image = ""
+			return c
+		}},
+		{name: "job executor without redistribimage", field: "spec.redistribimage", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.RedisTribImage = ""
+			return c
+		}},
+		{name: "invalid storage", field: "spec.storage", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Storage = "lots"
+			return c
+		}},
+		{name: "zero storage", field: "spec.storage", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Storage = "0"
+			return c
+		}},
+		{name: "protected config", field: "spec.config[dir]", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Config = map[string]string{"dir": "/tmp"}
+			return c
+		}},
+		{name: "unsafe config", field: "spec.config[port]", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Config = map[string]string{"port": "6380"}
+			return c
+		}},
+		{name: "unknown config", field: "spec.config[no-such-directive]", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Config = map[string]string{"no-such-directive": "yes"}
+			return c
+		}},
+		{name: "multiple values", field: "spec.config[maxmemory]", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Config = map[string]string{"maxmemory": "1gb\n2gb"}
+			return c
+		}},
+		{name: "auth without secret", field: "spec.auth.secretRef.name", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Auth = &v1alpha1.RedisClusterAuth{}
+			return c
+		}},
+		{name: "reserved pod label", field: "spec.podTemplate.labels[" + v1alpha1.RoleLabel + "]",
+			cluster: func() *v1alpha1.RedisCluster {
+				c := validCluster()
+				c.Spec.PodTemplate = &v1alpha1.RedisPodTemplate{Labels: map[string]string{v1alpha1.RoleLabel: "master"}}
+				return c
+			}},
+		{name: "unknown deletionPolicy", field: "spec.deletionPolicy", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.DeletionPolicy = "Archive"
+			return c
+		}},
+		{name: "unknown executor", field: "spec.updateStrategy.executor", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.UpdateStrategy = &v1alpha1.RedisClusterUpdateStrategy{Executor: "Cron"}
+			return c
+		}},
+		{name: "sentinel in cluster mode", field: "spec.sentinel", cluster: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Sentinel = &v1alpha1.RedisSentinelSpec{}
+			return c
+		}},
+		{name: "tls with sentinel", field: "spec.tls", cluster: func() *v1alpha1.RedisCluster {
+			c := validSentinelCluster()
+			c.Spec.TLS = &v1alpha1.RedisClusterTLS{SecretRef: corev1.LocalObjectReference{Name: "redis-tls"}}
+			return c
+		}},
+		{name: "sentinel with several shards", field: "spec.shards", cluster: func() *v1alpha1.RedisCluster {
+			c := validSentinelCluster()
+			c.Spec.Shards = int32Ptr(3)
+			return c
+		}},
+		{name: "sentinel quorum too large", field: "spec.sentinel.quorum", cluster: func() *v1alpha1.RedisCluster {
+			c := validSentinelCluster()
+			c.Spec.Sentinel = &v1alpha1.RedisSentinelSpec{Replicas: int32Ptr(3), Quorum: int32Ptr(4)}
+			return c
+		}},
+		{name: "no sentinels", field: "spec.sentinel.replicas", cluster: func() *v1alpha1.RedisCluster {
+			c := validSentinelCluster()
+			c.Spec.Sentinel = &v1alpha1.RedisSentinelSpec{Replicas: int32Ptr(0)}
+			return c
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkErrors(t, ValidateRedisCluster(test.cluster()), test.field)
+		})
+	}
+}
+
+func TestValidateRedisClusterUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    func() *v1alpha1.RedisCluster
+		update func(c *v1alpha1.RedisCluster)
+		//为空表示合法
+		field string
+	}{
+		{name: "scale up", old: validCluster, update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.Shards = int32Ptr(4)
+		}},
+		{name: "metadata only", old: validCluster, update: func(c *v1alpha1.RedisCluster) {
+			c.Labels = map[string]string{"team": "cache"}
+		}},
+		{name: "legacy replicas to shards", old: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = nil
+			c.Spec.Replicas = int32Ptr(6)
+			return c
+		}, update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.Shards = int32Ptr(3)
+			c.Spec.Replicas = nil
+		}},
+		{name: "sentinel replicas change", old: validSentinelCluster, update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.ReplicasPerShard = int32Ptr(3)
+		}},
+		{name: "stale clustermode restored", old: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Annotations = map[string]string{v1alpha1.LegacySpecAnnotation: "{}"}
+			c.Spec.ClusterMode = boolPtr(false)
+			return c
+		}, update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.ClusterMode = boolPtr(true)
+		}},
+		{name: "invalid old spec still editable", old: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = int32Ptr(2)
+			return c
+		}, update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.Image = "redis:6.0"
+		}},
+
+		{name: "invalid new spec", old: validCluster, field: "spec.shards", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.Shards = int32Ptr(2)
+		}},
+		{name: "storage", old: validCluster, field: "spec.storage", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.Storage = "2Gi"
+		}},
+		{name: "storageClassName", old: validCluster, field: "spec.storageClassName", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.StorageClassName = "fast"
+		}},
+		{name: "replicasPerShard", old: validCluster, field: "spec.replicasPerShard", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.ReplicasPerShard = int32Ptr(2)
+		}},
+		{name: "enable tls", old: validCluster, field: "spec.tls", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.TLS = &v1alpha1.RedisClusterTLS{SecretRef: corev1.LocalObjectReference{Name: "redis-tls"}}
+		}},
+		{name: "clustermode", old: validCluster, field: "spec.clustermode", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.ClusterMode = boolPtr(false)
+			c.Spec.Shards = nil
+		}},
+		{name: "immutable field of an invalid old spec", old: func() *v1alpha1.RedisCluster {
+			c := validCluster()
+			c.Spec.Shards = int32Ptr(2)
+			return c
+		}, field: "spec.storage", update: func(c *v1alpha1.RedisCluster) {
+			c.Spec.Storage = "2Gi"
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldCluster := test.old()
+			newCluster := oldCluster.DeepCopy()
+			test.update(newCluster)
+			errs := ValidateRedisClusterUpdate(newCluster, oldCluster)
+			checkErrors(t, errs, test.field)
+			//原来的spec不合法时，只报告不能修改的字段
+			if len(test.field) > 0 && len(ValidateRedisCluster(oldCluster)) > 0 {
+				for _, err := range errs {
+					if !strings.Contains(err.Detail, "immutable") {
+						t.Errorf("unexpected error for an invalid old spec: %v", err)
+					}
+				}
+			}
+		})
+	}
+}
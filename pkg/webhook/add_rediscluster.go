@@ -0,0 +1,10 @@
+package webhook
+
+import (
+	"xzbc-redis-cluster/pkg/webhook/rediscluster"
+)
+
+func init() {
+	// AddToManagerFuncs is a list of functions to create webhooks and add them to a manager.
+	AddToManagerFuncs = append(AddToManagerFuncs, rediscluster.Add)
+}
@@ -0,0 +1,154 @@
+package rediscluster
+
+import (
+	"strings"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+//记录webhook填充了哪些默认值，多个字段用逗号分隔
+const DefaultedFieldsAnnotation = "crd.xzbc.com.cn/defaulted-fields"
+
+// Defaults holds the operator-level values used to fill in unset RedisClusterSpec fields
+type Defaults struct {
+	Image            string
+	RedisTribImage   string
+	StorageClassName string
+	Storage          string
+	ReplicasPerShard int32
+	CPURequest       string
+	MemoryRequest    string
+	CPULimit         string
+	MemoryLimit      string
+}
+
+//operator启动参数里配置的默认值
+var defaults = Defaults{
+	Image:            "redis:5.0",
+	RedisTribImage:   "redis-trib:1.0",
+	Storage:          "5Gi",
+	ReplicasPerShard: crdv1alpha1.DefaultReplicasPerShard,
+}
+
+// FlagSet returns the flags used to configure the RedisCluster defaults
+func FlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("rediscluster-defaults", pflag.ExitOnError)
+	flagSet.StringVar(&defaults.Image, "default-image", defaults.Image,
+		"Redis image used when spec.image is not set")
+	flagSet.StringVar(&defaults.RedisTribImage, "default-redistrib-image", defaults.RedisTribImage,
+		"Image of the create and scale jobs used when spec.redistribimage is not set")
+	flagSet.StringVar(&defaults.StorageClassName, "default-storage-class", defaults.StorageClassName,
+		"StorageClass used when spec.storageClassName is not set, empty means the cluster default")
+	flagSet.StringVar(&defaults.Storage, "default-storage", defaults.Storage,
+		"Volume size used when spec.storage is not set")
+	flagSet.Int32Var(&defaults.ReplicasPerShard, "default-replicas-per-shard", defaults.ReplicasPerShard,
+		"Number of slaves per master used when spec.replicasPerShard is not set")
+	flagSet.StringVar(&defaults.CPURequest, "default-cpu-request", defaults.CPURequest,
+		"CPU request used when spec.resources is not set")
+	flagSet.StringVar(&defaults.MemoryRequest, "default-memory-request", defaults.MemoryRequest,
+		"Memory request used when spec.resources is not set")
+	flagSet.StringVar(&defaults.CPULimit, "default-cpu-limit", defaults.CPULimit,
+		"CPU limit used when spec.resources is not set")
+	flagSet.StringVar(&defaults.MemoryLimit, "default-memory-limit", defaults.MemoryLimit,
+		"Memory limit used when spec.resources is not set")
+	return flagSet
+}
+
+//给spec中没有设置的字段填充默认值，返回被填充的字段
+func (d *Defaults) apply(spec *crdv1alpha1.RedisClusterSpec) []string {
+	var applied []string
+
+	if len(spec.Image) == 0 && len(d.Image) > 0 {
+		spec.Image = d.Image
+		applied = append(applied, "spec.image")
+	}
+	if len(spec.RedisTribImage) == 0 && len(d.RedisTribImage) > 0 {
+		spec.RedisTribImage = d.RedisTribImage
+		applied = append(applied, "spec.redistribimage")
+	}
+	if len(spec.StorageClassName) == 0 && len(d.StorageClassName) > 0 {
+		spec.StorageClassName = d.StorageClassName
+		applied = append(applied, "spec.storageClassName")
+	}
+	if len(spec.Storage) == 0 && len(d.Storage) > 0 {
+		spec.Storage = d.Storage
+		applied = append(applied, "spec.storage")
+	}
+
+	//老的replicasPerMaster字段也算设置过
+	//只设置了replicas的老的CR按每个master一个slave换算分片个数，填充了别的默认值会改变replicas的含义
+	if spec.ReplicasPerShard == nil && spec.ReplicasPerMaster == nil && spec.Replicas == nil {
+		replicasPerShard := d.ReplicasPerShard
+		spec.ReplicasPerShard = &replicasPerShard
+		applied = append(applied, "spec.replicasPerShard")
+	}
+
+	if len(spec.Resources.Requests) == 0 && len(spec.Resources.Limits) == 0 {
+		requests := resourceList(d.CPURequest, d.MemoryRequest)
+		limits := resourceList(d.CPULimit, d.MemoryLimit)
+		if len(requests) > 0 || len(limits) > 0 {
+			spec.Resources = corev1.ResourceRequirements{Requests: requests, Limits: limits}
+			applied = append(applied, "spec.resources")
+		}
+	}
+
+	return applied
+}
+
+func resourceList(cpu, memory string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	if len(cpu) > 0 {
+		list[corev1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if len(memory) > 0 {
+		list[corev1.ResourceMemory] = resource.MustParse(memory)
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// Validate checks the configured defaults, so that a bad flag fails at startup
+// instead of when the first RedisCluster is admitted
+func (d *Defaults) Validate() error {
+	for _, value := range []string{d.Storage, d.CPURequest, d.MemoryRequest, d.CPULimit, d.MemoryLimit} {
+		if len(value) == 0 {
+			continue
+		}
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateDefaults checks the defaults configured through FlagSet
+func ValidateDefaults() error {
+	return defaults.Validate()
+}
+
+//合并annotation里已经记录的字段和这次填充的字段
+func mergeDefaultedFields(existing string, applied []string) string {
+	var fields []string
+	if len(existing) > 0 {
+		fields = strings.Split(existing, ",")
+	}
+	for _, field := range applied {
+		found := false
+		for _, f := range fields {
+			if f == field {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, ",")
+}
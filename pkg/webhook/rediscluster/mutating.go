@@ -0,0 +1,66 @@
+package rediscluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//在RedisCluster进入Reconcile之前，给没有设置的spec字段填充operator配置的默认值
+type redisClusterDefaulter struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &redisClusterDefaulter{}
+
+func (d *redisClusterDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func (d *redisClusterDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	redisCluster := &crdv1alpha1.RedisCluster{}
+	if err := d.decoder.Decode(req, redisCluster); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	//只在创建时填充默认值，storageClassName、replicasPerShard等字段创建之后不能修改，
+	//更新时填充会让之后的每次更新（包括operator自己添加和去掉finalizer）都被拒绝
+	var applied []string
+	if req.Operation == admissionv1beta1.Create {
+		applied = defaults.apply(&redisCluster.Spec)
+	}
+
+	//老版本operator写入的clustermode: false没有意义，这些集群都是redis cluster
+	//改回true是允许的修改，更新时也要处理
+	if redisCluster.HasStaleClusterMode() {
+		clusterMode := true
+		redisCluster.Spec.ClusterMode = &clusterMode
+		applied = append(applied, "spec.clustermode")
+	}
+	if len(applied) == 0 {
+		return admission.Allowed("")
+	}
+
+	//annotation只在创建时记录，更新时不改写
+	if req.Operation == admissionv1beta1.Create {
+		if redisCluster.Annotations == nil {
+			redisCluster.Annotations = map[string]string{}
+		}
+		redisCluster.Annotations[DefaultedFieldsAnnotation] =
+			mergeDefaultedFields(redisCluster.Annotations[DefaultedFieldsAnnotation], applied)
+	}
+
+	marshaled, err := json.Marshal(redisCluster)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	log.Info("Defaulted RedisCluster", "Namespace", req.Namespace, "Name", redisCluster.Name,
+		"fields", applied)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
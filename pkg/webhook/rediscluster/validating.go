@@ -0,0 +1,65 @@
+package rediscluster
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	crdv1alpha1 "xzbc-redis-cluster/pkg/apis/crd/v1alpha1"
+	"xzbc-redis-cluster/pkg/validation"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var log = logf.Log.WithName("webhook_rediscluster")
+
+//创建和更新RedisCluster时做校验，拒绝会让operator崩溃或者集群布局出错的CR
+type redisClusterValidator struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &redisClusterValidator{}
+
+func (v *redisClusterValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+func (v *redisClusterValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	redisCluster := &crdv1alpha1.RedisCluster{}
+	if err := v.decoder.Decode(req, redisCluster); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var allErrs field.ErrorList
+	switch req.Operation {
+	case admissionv1beta1.Create:
+		allErrs = validation.ValidateRedisCluster(redisCluster)
+	case admissionv1beta1.Update:
+		oldCluster := &crdv1alpha1.RedisCluster{}
+		if err := v.decoder.DecodeRaw(req.OldObject, oldCluster); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		//删除过程中和spec没有变化的更新（去掉finalizer、修改annotation）不校验，
+		//否则不合法的老CR去不掉finalizer，永远无法删除
+		if redisCluster.DeletionTimestamp != nil || reflect.DeepEqual(oldCluster.Spec, redisCluster.Spec) {
+			return admission.Allowed("")
+		}
+		allErrs = validation.ValidateRedisClusterUpdate(redisCluster, oldCluster)
+	default:
+		return admission.Allowed("")
+	}
+
+	if len(allErrs) > 0 {
+		err := apierrors.NewInvalid(crdv1alpha1.SchemeGroupVersion.WithKind("RedisCluster").GroupKind(),
+			redisCluster.Name, allErrs)
+		log.Info("Rejected RedisCluster", "Namespace", req.Namespace, "Name", redisCluster.Name,
+			"Operation", req.Operation, "error", err.Error())
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
@@ -0,0 +1,20 @@
+package rediscluster
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+//webhook的路径，需要和deploy/webhook.yaml里的配置保持一致
+const (
+	MutatingWebhookPath   = "/mutate-crd-xzbc-com-cn-v1alpha1-rediscluster"
+	ValidatingWebhookPath = "/validate-crd-xzbc-com-cn-v1alpha1-rediscluster"
+)
+
+// Add registers the RedisCluster admission webhooks with the Manager's webhook server
+func Add(mgr manager.Manager) error {
+	server := mgr.GetWebhookServer()
+	server.Register(MutatingWebhookPath, &webhook.Admission{Handler: &redisClusterDefaulter{}})
+	server.Register(ValidatingWebhookPath, &webhook.Admission{Handler: &redisClusterValidator{}})
+	return nil
+}